@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
@@ -17,30 +18,61 @@ import (
 )
 
 func main() {
+	migrateDown := flag.Int("migrate-down", 0, "roll back the last N applied migrations and exit")
+	flag.Parse()
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 	logger.Init()
 	log := logger.Log
 	log.Info("starting Subscriptions-service")
 	cfg := config.Load()
-	log.Infof("config loaded (port=%s, db=%s)", cfg.AppPort, cfg.DB.Host)
+	log.Infof("config loaded (port=%s, storage=%s, db=%s)", cfg.AppPort, cfg.Storage, cfg.DB.Host)
 	if os.Getenv("MIGRATIONS_DIR") == "" {
 		_ = os.Setenv("MIGRATIONS_DIR", "./migrations")
 	}
-	r, err := repo.New(ctx, cfg.DB.DSN)
-	if err != nil {
-		log.Fatalf("failed to init repo: %v", err)
+	if *migrateDown > 0 {
+		if err := repo.MigrateDown(ctx, cfg.DB.DSN, *migrateDown); err != nil {
+			log.Fatalf("migrate down: %v", err)
+		}
+		log.Infof("rolled back %d migration(s)", *migrateDown)
+		return
+	}
+	var r repo.Store
+	switch cfg.Storage {
+	case "memory":
+		log.Warn("using in-memory storage, data will not survive a restart")
+		r = repo.NewMemory()
+	case "postgres":
+		pg, err := repo.New(ctx, cfg.DB.DSN)
+		if err != nil {
+			log.Fatalf("failed to init repo: %v", err)
+		}
+		r = pg
+	default:
+		log.Fatalf("unknown storage %q (expected postgres or memory)", cfg.Storage)
 	}
 	defer r.Close()
+	if cfg.RatesFile != "" {
+		rates, err := config.LoadRates(cfg.RatesFile)
+		if err != nil {
+			log.Fatalf("failed to load exchange rates: %v", err)
+		}
+		if err := r.UpsertRates(ctx, rates); err != nil {
+			log.Fatalf("failed to store exchange rates: %v", err)
+		}
+		log.Infof("loaded %d exchange rates from %s", len(rates), cfg.RatesFile)
+	}
 	app := fiber.New(fiber.Config{
 		AppName:      "Subscriptions Service",
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
+		ErrorHandler: httpapi.ErrorHandler,
 	})
 
 	app.Use(recovermw.New())
 
-	h := httpapi.NewHandler(r)
+	h := httpapi.NewHandler(r, cfg)
 	httpapi.Setup(app, h)
 
 	go func() {
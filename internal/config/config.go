@@ -5,15 +5,33 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v3"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
 )
 
 type Config struct {
 	AppPort  string `yaml:"app_port"`
 	LogLevel string `yaml:"log_level"`
-	DB       struct {
+	Storage  string `yaml:"storage"` // postgres | memory
+	// BaseCurrency is the currency exchange rates are quoted against and
+	// the default for new subscriptions and summaries.
+	BaseCurrency string `yaml:"base_currency"`
+	RatesFile    string `yaml:"rates_file"`
+	// SoftDeleteRetention is how long deleted subscriptions are kept
+	// before an admin purge removes them.
+	SoftDeleteRetention time.Duration `yaml:"soft_delete_retention"`
+	// RequireIfMatch rejects PUT, PATCH and DELETE without an If-Match header.
+	RequireIfMatch bool `yaml:"require_if_match"`
+	// IdempotencyTTL is how long an Idempotency-Key is remembered.
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+	// CursorSecret signs list pagination cursors. It must be shared by all
+	// replicas; when empty a random key is used per process.
+	CursorSecret string `yaml:"cursor_secret"`
+	DB           struct {
 		Host string `yaml:"host"`
 		Port int    `yaml:"port"`
 		User string `yaml:"user"`
@@ -42,6 +60,39 @@ func Load() *Config {
 	if v := os.Getenv("LOG_LEVEL"); v != "" {
 		cfg.LogLevel = v
 	}
+	if v := os.Getenv("STORAGE"); v != "" {
+		cfg.Storage = v
+	}
+	if v := os.Getenv("BASE_CURRENCY"); v != "" {
+		cfg.BaseCurrency = v
+	}
+	if v := os.Getenv("RATES_FILE"); v != "" {
+		cfg.RatesFile = v
+	}
+	if v := os.Getenv("SOFT_DELETE_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid SOFT_DELETE_RETENTION: %v", err)
+		}
+		cfg.SoftDeleteRetention = d
+	}
+	if v := os.Getenv("REQUIRE_IF_MATCH"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid REQUIRE_IF_MATCH: %v", err)
+		}
+		cfg.RequireIfMatch = b
+	}
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid IDEMPOTENCY_TTL: %v", err)
+		}
+		cfg.IdempotencyTTL = d
+	}
+	if v := os.Getenv("CURSOR_SECRET"); v != "" {
+		cfg.CursorSecret = v
+	}
 	if v := os.Getenv("DB_HOST"); v != "" {
 		cfg.DB.Host = v
 	}
@@ -74,6 +125,45 @@ func Load() *Config {
 	if cfg.LogLevel == "" {
 		cfg.LogLevel = "info"
 	}
+	if cfg.Storage == "" {
+		cfg.Storage = "postgres"
+	}
+	if cfg.SoftDeleteRetention <= 0 {
+		cfg.SoftDeleteRetention = 30 * 24 * time.Hour
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		cfg.IdempotencyTTL = 24 * time.Hour
+	}
+	if cfg.BaseCurrency == "" {
+		cfg.BaseCurrency = "RUB"
+	}
+	if code, ok := domain.NormalizeCurrency(cfg.BaseCurrency); ok {
+		cfg.BaseCurrency = code
+	} else {
+		log.Fatalf("unsupported base_currency %q", cfg.BaseCurrency)
+	}
 
 	return cfg
 }
+
+// LoadRates reads an exchange-rate file: a YAML (or JSON) list of
+// {currency, month, rate} entries quoted against BaseCurrency.
+func LoadRates(path string) ([]domain.ExchangeRate, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rates file %s: %w", path, err)
+	}
+	var in []domain.ExchangeRateDTO
+	if err := yaml.Unmarshal(body, &in); err != nil {
+		return nil, fmt.Errorf("parse rates file %s: %w", path, err)
+	}
+	out := make([]domain.ExchangeRate, 0, len(in))
+	for _, d := range in {
+		rt, err := d.ToRate()
+		if err != nil {
+			return nil, fmt.Errorf("rates file %s: %w", path, err)
+		}
+		out = append(out, rt)
+	}
+	return out, nil
+}
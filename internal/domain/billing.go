@@ -0,0 +1,149 @@
+package domain
+
+import "time"
+
+// BillingPeriod is how often a subscription is charged. Charges fall on the
+// anchor (start) date and then every period after it.
+type BillingPeriod string
+
+const (
+	BillingWeekly    BillingPeriod = "weekly"
+	BillingMonthly   BillingPeriod = "monthly"
+	BillingQuarterly BillingPeriod = "quarterly"
+	BillingYearly    BillingPeriod = "yearly"
+)
+
+// SummaryBasis selects how Summary counts costs: actual charges falling
+// into the period, a normalised monthly equivalent of the price, or that
+// equivalent prorated by the days the subscription was active each month.
+type SummaryBasis string
+
+const (
+	BasisCharges  SummaryBasis = "charges"
+	BasisMonthly  SummaryBasis = "monthly_equivalent"
+	BasisProrated SummaryBasis = "prorated"
+)
+
+func (p BillingPeriod) Valid() bool {
+	switch p {
+	case BillingWeekly, BillingMonthly, BillingQuarterly, BillingYearly:
+		return true
+	}
+	return false
+}
+
+// ChargesIn returns how many charges of a subscription anchored at anchor
+// and active until end (inclusive, nil if open-ended) fall into the
+// calendar month starting at month. month must not precede anchor's month.
+// Monthly-based periods charge on anchor's day of month, clamped to the
+// month's length.
+func (p BillingPeriod) ChargesIn(anchor time.Time, end *time.Time, month time.Time) int {
+	return p.PaidChargesIn(anchor, anchor, end, month)
+}
+
+// PaidChargesIn is ChargesIn counting only the charges falling on or after
+// paidFrom, such as the first day after a free trial.
+func (p BillingPeriod) PaidChargesIn(anchor, paidFrom time.Time, end *time.Time, month time.Time) int {
+	first, last := month, month.AddDate(0, 1, -1)
+	if paidFrom.After(first) {
+		first = paidFrom
+	}
+	if end != nil && end.Before(last) {
+		last = *end
+	}
+	if p == BillingWeekly {
+		d1 := max(daysBetween(anchor, first), 0)
+		d2 := daysBetween(anchor, last)
+		if d2 < d1 {
+			return 0
+		}
+		return d2/7 - (d1+6)/7 + 1
+	}
+	step := 1
+	switch p {
+	case BillingQuarterly:
+		step = 3
+	case BillingYearly:
+		step = 12
+	}
+	diff := (month.Year()-anchor.Year())*12 + int(month.Month()-anchor.Month())
+	if diff%step != 0 {
+		return 0
+	}
+	day := min(anchor.Day(), DaysInMonth(month))
+	if charge := month.AddDate(0, 0, day-1); charge.Before(first) || charge.After(last) {
+		return 0
+	}
+	return 1
+}
+
+// ActiveShare is the fraction of month's days covered by [start, end]
+// (end inclusive, nil if open-ended).
+func ActiveShare(start time.Time, end *time.Time, month time.Time) float64 {
+	first, last := month, month.AddDate(0, 1, -1)
+	if start.After(first) {
+		first = start
+	}
+	if end != nil && end.Before(last) {
+		last = *end
+	}
+	days := daysBetween(first, last) + 1
+	if days <= 0 {
+		return 0
+	}
+	return float64(days) / float64(DaysInMonth(month))
+}
+
+func DaysInMonth(month time.Time) int {
+	return month.AddDate(0, 1, -1).Day()
+}
+
+// MonthlyFactor is the share of the price attributed to each month when
+// reporting monthly equivalents.
+func (p BillingPeriod) MonthlyFactor() float64 {
+	switch p {
+	case BillingWeekly:
+		return 52.0 / 12
+	case BillingQuarterly:
+		return 1.0 / 3
+	case BillingYearly:
+		return 1.0 / 12
+	default:
+		return 1
+	}
+}
+
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// Anchor is the date charges are counted from: the exact start date when
+// known, the first of the start month otherwise.
+func (s Subscription) Anchor() time.Time {
+	if s.StartDate != nil {
+		return *s.StartDate
+	}
+	return s.StartMonth
+}
+
+// PaidFrom is the first day charges are counted on: the day after the
+// trial, or the anchor when there is none.
+func (s Subscription) PaidFrom() time.Time {
+	if s.TrialUntil != nil && !s.TrialUntil.Before(s.Anchor()) {
+		return s.TrialUntil.AddDate(0, 0, 1)
+	}
+	return s.Anchor()
+}
+
+// LastDay is the last active day (inclusive): the exact end date when
+// known, the end of the end month otherwise, nil if open-ended.
+func (s Subscription) LastDay() *time.Time {
+	if s.EndDate != nil {
+		return s.EndDate
+	}
+	if s.EndMonth != nil {
+		t := s.EndMonth.AddDate(0, 1, -1)
+		return &t
+	}
+	return nil
+}
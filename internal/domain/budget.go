@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Budget is a monthly spending limit of a user. Category and ServiceName
+// narrow it to the matching subscriptions; nil means any. A ServiceName
+// found in the catalog is replaced with the canonical name and linked
+// through ServiceID, as for subscriptions.
+type Budget struct {
+	ID          uuid.UUID  `db:"id"            json:"id"`
+	UserID      uuid.UUID  `db:"user_id"       json:"user_id"`
+	Category    *string    `db:"category"      json:"category"`
+	ServiceName *string    `db:"service_name"  json:"service_name"`
+	ServiceID   *uuid.UUID `db:"service_id"    json:"service_id"`
+	Limit       int        `db:"monthly_limit" json:"limit"` // minor units of Currency
+	Currency    string     `db:"currency"      json:"currency"`
+	CreatedAt   time.Time  `db:"created_at"    json:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at"    json:"updated_at"`
+}
+
+// BudgetDTO is the request body of the budgets endpoints.
+type BudgetDTO struct {
+	UserID      uuid.UUID `json:"user_id"      example:"60601fee-2bf1-4721-ae6f-7636e79a0cba"`
+	Category    *string   `json:"category"     example:"entertainment"`
+	ServiceName *string   `json:"service_name" example:"Netflix"`
+	Limit       int       `json:"limit"        example:"150000"`
+	Currency    string    `json:"currency,omitempty" example:"RUB"`
+}
+
+// BudgetMonth compares the spending of one month with the limit.
+// Remaining is negative when the budget is exceeded.
+type BudgetMonth struct {
+	Month      string `json:"month"`
+	Spent      int    `json:"spent"`
+	Limit      int    `json:"limit"`
+	Remaining  int    `json:"remaining"`
+	OverBudget bool   `json:"over_budget"`
+}
+
+type BudgetStatus struct {
+	Budget     Budget        `json:"budget"`
+	Months     []BudgetMonth `json:"months"`
+	OverBudget bool          `json:"over_budget"`
+}
+
+// BudgetStatusResponse is the body of GET /users/{id}/budget-status.
+// OverBudget is set when any budget is exceeded in any month.
+type BudgetStatusResponse struct {
+	UserID     uuid.UUID      `json:"user_id"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Budgets    []BudgetStatus `json:"budgets"`
+	OverBudget bool           `json:"over_budget"`
+}
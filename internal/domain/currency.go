@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pavel97go/subscriptions/internal/util"
+)
+
+// CurrencyExponents lists the supported ISO 4217 currencies and the number
+// of minor units each one has (kopecks, cents, ...).
+var CurrencyExponents = map[string]int{
+	"RUB": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"KZT": 2,
+	"BYN": 2,
+	"UAH": 2,
+	"TRY": 2,
+	"AMD": 2,
+	"GEL": 2,
+	"JPY": 0,
+	"KRW": 0,
+}
+
+// NormalizeCurrency upper-cases code and reports whether it is supported.
+func NormalizeCurrency(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	_, ok := CurrencyExponents[code]
+	return code, ok
+}
+
+type ExchangeRate struct {
+	Currency string    `db:"currency"`
+	Month    time.Time `db:"month"`
+	Rate     float64   `db:"rate"`
+}
+
+// ExchangeRateDTO is the wire and rates-file form of ExchangeRate.
+// Rate is the price of one major unit of Currency in the base currency.
+type ExchangeRateDTO struct {
+	Currency string  `json:"currency" yaml:"currency" example:"USD"`
+	Month    string  `json:"month"    yaml:"month"    example:"07-2025"`
+	Rate     float64 `json:"rate"     yaml:"rate"     example:"92.5"`
+}
+
+// ToRate validates d and converts it to an ExchangeRate.
+func (d ExchangeRateDTO) ToRate() (ExchangeRate, error) {
+	code, ok := NormalizeCurrency(d.Currency)
+	if !ok {
+		return ExchangeRate{}, fmt.Errorf("unsupported currency %q", d.Currency)
+	}
+	month, err := util.ParseMonth(d.Month)
+	if err != nil {
+		return ExchangeRate{}, fmt.Errorf("invalid month %q, expected MM-YYYY", d.Month)
+	}
+	if d.Rate <= 0 {
+		return ExchangeRate{}, fmt.Errorf("rate for %s %s must be > 0", code, d.Month)
+	}
+	return ExchangeRate{Currency: code, Month: month, Rate: d.Rate}, nil
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+)
+
+// MaxLabelLen and MaxTags bound the categories and tags of a subscription.
+const (
+	MaxLabelLen = 50
+	MaxTags     = 20
+)
+
+// NormalizeLabel normalizes a category or tag: lower case with runs of
+// white space collapsed.
+func NormalizeLabel(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// NormalizeTags normalizes every tag and returns them sorted and distinct,
+// dropping empty ones. The result is never nil.
+func NormalizeTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t = NormalizeLabel(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
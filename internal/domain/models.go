@@ -1,37 +1,226 @@
 package domain
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type SubscriptionDTO struct {
-	ServiceName string    `json:"service_name" example:"Yandex Plus"`
-	Price       int       `json:"price"        example:"400"` // rub
-	UserID      uuid.UUID `json:"user_id"    example:"60601fee-2bf1-4721-ae6f-7636e79a0cba"`
-	StartDate   string    `json:"start_date"   example:"07-2025"`
-	EndDate     *string   `json:"end_date,omitempty" example:"09-2025"`
+	ServiceName   string    `json:"service_name" example:"Yandex Plus"`
+	Price         int       `json:"price"        example:"39900"` // minor units of Currency
+	Currency      string    `json:"currency,omitempty" example:"RUB"`
+	BillingPeriod string    `json:"billing_period,omitempty" example:"monthly"` // weekly | monthly | quarterly | yearly
+	UserID        uuid.UUID `json:"user_id"    example:"60601fee-2bf1-4721-ae6f-7636e79a0cba"`
+	StartDate     string    `json:"start_date"   example:"07-2025"` // MM-YYYY or YYYY-MM-DD
+	EndDate       *string   `json:"end_date,omitempty" example:"09-2025"`
+	TrialUntil    *string   `json:"trial_until,omitempty" example:"2025-07-14"` // last free day; MM-YYYY means the whole month
+	Category      *string   `json:"category,omitempty" example:"entertainment"`
+	Tags          []string  `json:"tags,omitempty"`
 }
 
 type SubscriptionResponse struct {
-	ID          uuid.UUID `json:"id"`
-	ServiceName string    `json:"service_name"`
-	Price       int       `json:"price"`
-	UserID      uuid.UUID `json:"user_id"`
-	StartDate   string    `json:"start_date"`
-	EndDate     *string   `json:"end_date,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID            uuid.UUID  `json:"id"`
+	ServiceName   string     `json:"service_name"`
+	ServiceID     *uuid.UUID `json:"service_id,omitempty"`
+	Price         int        `json:"price"`
+	Currency      string     `json:"currency"`
+	BillingPeriod string     `json:"billing_period"`
+	UserID        uuid.UUID  `json:"user_id"`
+	StartDate     string     `json:"start_date"`
+	EndDate       *string    `json:"end_date,omitempty"`
+	TrialUntil    *string    `json:"trial_until,omitempty"`
+	Category      *string    `json:"category,omitempty"`
+	Tags          []string   `json:"tags"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	ETag          string     `json:"etag"`
+}
+
+// ListResponse is the envelope of GET /subscriptions in cursor mode or
+// with envelope=true. Total is only counted in the latter case.
+type ListResponse struct {
+	Items      []SubscriptionResponse `json:"items"`
+	Total      *int                   `json:"total,omitempty"`
+	Limit      int                    `json:"limit"`
+	Offset     *int                   `json:"offset,omitempty"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+	PrevCursor string                 `json:"prev_cursor,omitempty"`
 }
 
 type Subscription struct {
-	ID          uuid.UUID  `db:"id"`
-	ServiceName string     `db:"service_name"`
-	Price       int        `db:"price"`
-	UserID      uuid.UUID  `db:"user_id"`
-	StartMonth  time.Time  `db:"start_month"`
-	EndMonth    *time.Time `db:"end_month"`
-	CreatedAt   time.Time  `db:"created_at"`
-	UpdatedAt   time.Time  `db:"updated_at"`
+	ID          uuid.UUID `db:"id"             json:"id"`
+	ServiceName string    `db:"service_name"   json:"service_name"`
+	// ServiceID links the subscription to the catalog entry its
+	// service_name resolved to, if any.
+	ServiceID *uuid.UUID `db:"service_id"     json:"service_id"`
+	// Category and Tags are normalized by NormalizeLabel and NormalizeTags.
+	// A subscription without a category takes the one of its service.
+	Category      *string       `db:"category"       json:"category"`
+	Tags          []string      `db:"tags"           json:"tags"`
+	Price         int           `db:"price"          json:"price"`
+	Currency      string        `db:"currency"       json:"currency"`
+	BillingPeriod BillingPeriod `db:"billing_period" json:"billing_period"`
+	UserID        uuid.UUID     `db:"user_id"        json:"user_id"`
+	StartMonth    time.Time     `db:"start_month"    json:"start_month"`
+	EndMonth      *time.Time    `db:"end_month"      json:"end_month"`
+	// StartDate and EndDate are set when the dates were given with day
+	// precision; StartMonth and EndMonth always hold their months.
+	StartDate *time.Time `db:"start_date" json:"start_date"`
+	EndDate   *time.Time `db:"end_date"   json:"end_date"`
+	// TrialUntil is the last day of a free trial, inclusive.
+	TrialUntil *time.Time `db:"trial_until" json:"trial_until"`
+	CreatedAt  time.Time  `db:"created_at"  json:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at"  json:"updated_at"`
+	DeletedAt  *time.Time `db:"deleted_at"  json:"deleted_at"`
+	// Version is incremented on every change and exposed as the ETag.
+	Version int `db:"version" json:"version"`
+}
+
+// PriceChange is a price of a subscription effective from a month until
+// the next change. Before the first change the subscription's own price
+// applies.
+type PriceChange struct {
+	SubscriptionID uuid.UUID `db:"subscription_id" json:"subscription_id"`
+	EffectiveFrom  time.Time `db:"effective_from"  json:"effective_from"`
+	Price          int       `db:"price"           json:"price"`
+	CreatedAt      time.Time `db:"created_at"      json:"created_at"`
+}
+
+type PriceChangeDTO struct {
+	Price         int    `json:"price"          example:"49900"`
+	EffectiveFrom string `json:"effective_from" example:"01-2026"`
+}
+
+type PriceChangeResponse struct {
+	Price         int       `json:"price"`
+	EffectiveFrom string    `json:"effective_from"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Pause is a range of months in which a subscription is not charged.
+// Until is the last paused month, nil while the pause is open-ended.
+type Pause struct {
+	SubscriptionID uuid.UUID  `db:"subscription_id" json:"subscription_id"`
+	From           time.Time  `db:"paused_from"     json:"from"`
+	Until          *time.Time `db:"paused_until"    json:"until"`
+	CreatedAt      time.Time  `db:"created_at"      json:"created_at"`
+}
+
+// Covers reports whether month is paused.
+func (p Pause) Covers(month time.Time) bool {
+	return !month.Before(p.From) && (p.Until == nil || !month.After(*p.Until))
+}
+
+// Overlaps reports whether p and o have a month in common.
+func (p Pause) Overlaps(o Pause) bool {
+	return (o.Until == nil || !p.From.After(*o.Until)) && (p.Until == nil || !o.From.After(*p.Until))
+}
+
+type PauseDTO struct {
+	From  string  `json:"from,omitempty"  example:"06-2026"` // MM-YYYY, the current month by default
+	Until *string `json:"until,omitempty" example:"08-2026"` // last paused month; open-ended when omitted
+}
+
+type ResumeDTO struct {
+	From string `json:"from,omitempty" example:"09-2026"` // first charged month again, the current month by default
+}
+
+type PauseResponse struct {
+	From      string    `json:"from"`
+	Until     *string   `json:"until,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type EventAction string
+
+const (
+	EventCreate      EventAction = "create"
+	EventUpdate      EventAction = "update"
+	EventDelete      EventAction = "delete"
+	EventPriceChange EventAction = "price_change"
+	EventRestore     EventAction = "restore"
+	EventPurge       EventAction = "purge"
+	EventPause       EventAction = "pause"
+	EventResume      EventAction = "resume"
+)
+
+// SubscriptionEvent is an audit record of a mutation. Before and After hold
+// JSON snapshots of the affected record and are null where not applicable.
+type SubscriptionEvent struct {
+	ID             int64           `db:"id"              json:"id"`
+	SubscriptionID uuid.UUID       `db:"subscription_id" json:"subscription_id"`
+	Actor          string          `db:"actor"           json:"actor"`
+	Action         EventAction     `db:"action"          json:"action"`
+	Before         json.RawMessage `db:"before"          json:"before"`
+	After          json.RawMessage `db:"after"           json:"after"`
+	CreatedAt      time.Time       `db:"created_at"      json:"created_at"`
+}
+
+type SummaryGroup string
+
+const (
+	GroupByService  SummaryGroup = "service_name"
+	GroupByUser     SummaryGroup = "user_id"
+	GroupByMonth    SummaryGroup = "month"
+	GroupByCategory SummaryGroup = "category"
+	// GroupByTag counts a subscription in the group of each of its tags, so
+	// the group totals may add up to more than the overall total.
+	GroupByTag SummaryGroup = "tag"
+)
+
+// SummaryRow is the total of one group. Category and Tag are nil for the
+// group of subscriptions without one.
+type SummaryRow struct {
+	ServiceName *string
+	UserID      *uuid.UUID
+	Month       *time.Time
+	Category    *string
+	Tag         *string
+	Total       int
+}
+
+type SummaryResult struct {
+	Total    int
+	Currency string
+	Rows     []SummaryRow
+}
+
+type SummaryItemResponse struct {
+	ServiceName *string    `json:"service_name,omitempty"`
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	Month       *string    `json:"month,omitempty"`
+	Category    *string    `json:"category,omitempty"`
+	Tag         *string    `json:"tag,omitempty"`
+	Total       int        `json:"total"`
+}
+
+type SummaryResponse struct {
+	Total    int                   `json:"total"`
+	Currency string                `json:"currency"`
+	Items    []SummaryItemResponse `json:"items"`
+}
+
+type ForecastPoint struct {
+	Month string `json:"month"`
+	Total int    `json:"total"`
+}
+
+type ForecastSeries struct {
+	ServiceName string          `json:"service_name"`
+	Total       int             `json:"total"`
+	Months      []ForecastPoint `json:"months"`
+}
+
+// ForecastResponse is the body of GET /subscriptions/forecast: projected
+// spend per month overall and per service.
+type ForecastResponse struct {
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Currency string           `json:"currency"`
+	Total    int              `json:"total"`
+	Months   []ForecastPoint  `json:"months"`
+	Services []ForecastSeries `json:"services"`
 }
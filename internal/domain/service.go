@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Service is a catalog entry. Subscriptions whose service_name matches its
+// name or one of its aliases (see ServiceKey) are linked to it and carry
+// its canonical Name.
+type Service struct {
+	ID           uuid.UUID `db:"id"            json:"id"`
+	Name         string    `db:"name"          json:"name"`
+	Aliases      []string  `db:"aliases"       json:"aliases"`
+	Category     *string   `db:"category"      json:"category"`
+	DefaultPrice *int      `db:"default_price" json:"default_price"` // minor units of Currency
+	Currency     string    `db:"currency"      json:"currency"`
+	VendorURL    *string   `db:"vendor_url"    json:"vendor_url"`
+	CreatedAt    time.Time `db:"created_at"    json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"    json:"updated_at"`
+}
+
+// ServiceDTO is the request body of the services endpoints.
+type ServiceDTO struct {
+	Name         string   `json:"name"          example:"Yandex Plus"`
+	Aliases      []string `json:"aliases"       example:"yandex plus,Яндекс Плюс"`
+	Category     *string  `json:"category"      example:"entertainment"`
+	DefaultPrice *int     `json:"default_price" example:"39900"`
+	Currency     string   `json:"currency,omitempty" example:"RUB"`
+	VendorURL    *string  `json:"vendor_url"    example:"https://plus.yandex.ru"`
+}
+
+// ServiceKey normalizes a service name or alias for case-insensitive
+// matching.
+func ServiceKey(name string) string {
+	return NormalizeLabel(name)
+}
+
+// Keys returns the distinct lookup keys of s: its name and aliases.
+func (s Service) Keys() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range append([]string{s.Name}, s.Aliases...) {
+		if k := ServiceKey(n); k != "" && !seen[k] {
+			seen[k] = true
+			out = append(out, k)
+		}
+	}
+	return out
+}
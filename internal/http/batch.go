@@ -0,0 +1,149 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+	"github.com/pavel97go/subscriptions/internal/repo"
+)
+
+// maxBatchOps caps the number of operations in one batch request.
+const maxBatchOps = 1000
+
+type batchRequest struct {
+	// Atomic commits all operations or none; otherwise each operation
+	// succeeds or fails on its own.
+	Atomic     bool               `json:"atomic"`
+	Operations []batchOperationIn `json:"operations"`
+}
+
+type batchOperationIn struct {
+	Op      string                  `json:"op"` // create | update | delete
+	ID      string                  `json:"id,omitempty"`
+	IfMatch string                  `json:"if_match,omitempty"`
+	Data    *domain.SubscriptionDTO `json:"data,omitempty"`
+}
+
+type batchResponse struct {
+	Committed bool              `json:"committed"`
+	Results   []batchItemResult `json:"results"`
+}
+
+type batchItemResult struct {
+	Index  int        `json:"index"`
+	Status int        `json:"status"`
+	ID     *uuid.UUID `json:"id,omitempty"`
+	ETag   string     `json:"etag,omitempty"`
+	Error  *Problem   `json:"error,omitempty"`
+}
+
+// Batch creates, updates and deletes many subscriptions in one request and
+// reports the outcome of every operation.
+func (h *Handler) Batch(c *fiber.Ctx) error {
+	var in batchRequest
+	if err := c.BodyParser(&in); err != nil {
+		return errInvalidBody
+	}
+	if len(in.Operations) == 0 {
+		return invalidField("operations", "must not be empty")
+	}
+	if len(in.Operations) > maxBatchOps {
+		return invalidField("operations", fmt.Sprintf("at most %d operations per batch", maxBatchOps))
+	}
+
+	instance := c.OriginalURL()
+	out := batchResponse{Results: make([]batchItemResult, len(in.Operations))}
+	ops := make([]repo.BatchOp, 0, len(in.Operations))
+	pos := make([]int, 0, len(in.Operations)) // index in the request of ops[i]
+	invalid := false
+	for i, raw := range in.Operations {
+		out.Results[i].Index = i
+		op, err := h.batchOp(reqCtx(c), raw)
+		if err != nil {
+			p := newProblem(err, instance)
+			out.Results[i].Status, out.Results[i].Error = p.Status, &p
+			invalid = true
+			continue
+		}
+		ops = append(ops, op)
+		pos = append(pos, i)
+	}
+	if invalid && in.Atomic {
+		p := newProblem(fiber.NewError(http.StatusFailedDependency, "not executed because another operation is invalid"), instance)
+		for i := range out.Results {
+			if out.Results[i].Error == nil {
+				out.Results[i].Status, out.Results[i].Error = p.Status, &p
+			}
+		}
+		return c.JSON(out)
+	}
+
+	logger.Log.Infof("http batch: operations=%d valid=%d atomic=%t", len(in.Operations), len(ops), in.Atomic)
+	results, err := h.r.Batch(reqCtx(c), ops, in.Atomic)
+	if err != nil {
+		return storeError("batch", err)
+	}
+	out.Committed = !in.Atomic || !invalid
+	for j, r := range results {
+		item := &out.Results[pos[j]]
+		if r.Err != nil {
+			if in.Atomic {
+				out.Committed = false
+			}
+			err := r.Err
+			if errors.Is(err, repo.ErrBatchAborted) {
+				err = fiber.NewError(http.StatusFailedDependency, "rolled back because another operation failed")
+			} else {
+				err = storeError("batch "+string(ops[j].Action), err)
+			}
+			p := newProblem(err, instance)
+			item.Status, item.Error = p.Status, &p
+			continue
+		}
+		item.Status = http.StatusOK
+		if ops[j].Action == repo.BatchCreate {
+			item.Status = http.StatusCreated
+		}
+		id := r.Sub.ID
+		item.ID, item.ETag = &id, etag(r.Sub.Version)
+	}
+	return c.JSON(out)
+}
+
+// batchOp validates one operation of a batch request.
+func (h *Handler) batchOp(ctx context.Context, in batchOperationIn) (repo.BatchOp, error) {
+	op := repo.BatchOp{Action: repo.BatchAction(in.Op)}
+	switch op.Action {
+	case repo.BatchCreate, repo.BatchUpdate, repo.BatchDelete:
+	default:
+		return op, invalidField("op", "expected create, update or delete")
+	}
+	if op.Action != repo.BatchCreate {
+		id, err := uuid.Parse(in.ID)
+		if err != nil {
+			return op, invalidField("id", "must be a subscription uuid")
+		}
+		op.ID = id
+		if op.IfVersion, err = h.matchVersion(ctx, id, in.IfMatch); err != nil {
+			return op, err
+		}
+	}
+	if op.Action != repo.BatchDelete {
+		if in.Data == nil {
+			return op, invalidField("data", "is required")
+		}
+		s, err := h.fromDTO(*in.Data)
+		if err != nil {
+			return op, err
+		}
+		op.Sub = s
+	}
+	return op, nil
+}
@@ -0,0 +1,228 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+	"github.com/pavel97go/subscriptions/internal/repo"
+	"github.com/pavel97go/subscriptions/internal/util"
+)
+
+// maxBudgetMonths bounds the period of a budget status report.
+const maxBudgetMonths = 60
+
+func (h *Handler) ListBudgets(c *fiber.Ctx) error {
+	var uid *uuid.UUID
+	if s := c.Query("user_id"); s != "" {
+		u, err := uuid.Parse(s)
+		if err != nil {
+			return invalidField("user_id", "expected a uuid")
+		}
+		uid = &u
+	}
+	out, err := h.r.ListBudgets(reqCtx(c), uid)
+	if err != nil {
+		return storeError("list budgets", err)
+	}
+	if out == nil {
+		out = []domain.Budget{}
+	}
+	return c.JSON(out)
+}
+
+func (h *Handler) GetBudget(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	b, err := h.r.GetBudget(reqCtx(c), id)
+	if err != nil {
+		return storeError("get budget", err)
+	}
+	return c.JSON(b)
+}
+
+func (h *Handler) CreateBudget(c *fiber.Ctx) error {
+	var in domain.BudgetDTO
+	if err := c.BodyParser(&in); err != nil {
+		return errInvalidBody
+	}
+	b, err := h.budgetFromDTO(in)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http create budget: user_id=%s limit=%d %s", b.UserID, b.Limit, b.Currency)
+	created, err := h.r.CreateBudget(reqCtx(c), b)
+	if err != nil {
+		return budgetStoreError("create budget", err)
+	}
+	return c.Status(http.StatusCreated).JSON(created)
+}
+
+func (h *Handler) UpdateBudget(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	var in domain.BudgetDTO
+	if err := c.BodyParser(&in); err != nil {
+		return errInvalidBody
+	}
+	b, err := h.budgetFromDTO(in)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http update budget: id=%s user_id=%s limit=%d %s", id, b.UserID, b.Limit, b.Currency)
+	updated, err := h.r.UpdateBudget(reqCtx(c), id, b)
+	if err != nil {
+		return budgetStoreError("update budget", err)
+	}
+	return c.JSON(updated)
+}
+
+func (h *Handler) DeleteBudget(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http delete budget: id=%s", id)
+	if err := h.r.DeleteBudget(reqCtx(c), id); err != nil {
+		return storeError("delete budget", err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// budgetFromDTO validates in and converts it to a domain.Budget,
+// reporting every invalid field at once.
+func (h *Handler) budgetFromDTO(in domain.BudgetDTO) (domain.Budget, error) {
+	var errs validationError
+	b := domain.Budget{UserID: in.UserID, Limit: in.Limit, Currency: h.cfg.BaseCurrency}
+	if in.UserID == uuid.Nil {
+		errs.add("user_id", "is required")
+	}
+	if in.Limit < 0 {
+		errs.add("limit", "must be >= 0")
+	}
+	if in.Currency != "" {
+		code, ok := domain.NormalizeCurrency(in.Currency)
+		if !ok {
+			errs.add("currency", "unsupported currency")
+		}
+		b.Currency = code
+	}
+	if in.Category != nil {
+		if cat := domain.NormalizeLabel(*in.Category); cat != "" {
+			b.Category = &cat
+		}
+		if b.Category != nil && len(*b.Category) > domain.MaxLabelLen {
+			errs.add("category", fmt.Sprintf("must be at most %d characters", domain.MaxLabelLen))
+		}
+	}
+	if in.ServiceName != nil {
+		if name := strings.TrimSpace(*in.ServiceName); name != "" {
+			b.ServiceName = &name
+		}
+	}
+	return b, errs.err()
+}
+
+// budgetStoreError is storeError with a clearer message for a duplicate
+// budget scope.
+func budgetStoreError(op string, err error) error {
+	if errors.Is(err, repo.ErrConflict) {
+		return fiber.NewError(http.StatusConflict, "the user already has a budget for this category and service")
+	}
+	return storeError(op, err)
+}
+
+// BudgetStatus reports, for every budget of the user and every month of
+// the period (the current month by default), how much was spent against
+// the limit. Spending is computed by the summary in the budget's currency.
+func (h *Handler) BudgetStatus(c *fiber.Ctx) error {
+	uid, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	var errs validationError
+	from := util.MonthStart(time.Now().UTC())
+	if s := c.Query("from"); s != "" {
+		if from, err = util.ParseMonth(s); err != nil {
+			errs.add("from", "expected MM-YYYY")
+		}
+	}
+	to := from
+	if s := c.Query("to"); s != "" {
+		if to, err = util.ParseMonth(s); err != nil {
+			errs.add("to", "expected MM-YYYY")
+		}
+	}
+	months := util.MonthsOverlap(from, &to, from, to)
+	if len(errs) == 0 {
+		if to.Before(from) {
+			errs.add("to", "must be >= from")
+		} else if months > maxBudgetMonths {
+			errs.add("to", fmt.Sprintf("period must be at most %d months", maxBudgetMonths))
+		}
+	}
+	basis, err := parseBasis(c.Query("basis"))
+	if err != nil {
+		errs.add("basis", err.Error())
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+	budgets, err := h.r.ListBudgets(reqCtx(c), &uid)
+	if err != nil {
+		return storeError("budget status", err)
+	}
+	logger.Log.Infof("http budget status: user_id=%s from=%s to=%s budgets=%d",
+		uid, util.MonthStr(from), util.MonthStr(to), len(budgets))
+
+	out := domain.BudgetStatusResponse{
+		UserID:  uid,
+		From:    util.MonthStr(from),
+		To:      util.MonthStr(to),
+		Budgets: make([]domain.BudgetStatus, 0, len(budgets)),
+	}
+	for _, b := range budgets {
+		res, err := h.r.Summary(reqCtx(c), repo.SummaryFilter{
+			UserID: &uid, ServiceName: b.ServiceName, Category: b.Category,
+			From: from, To: to, GroupBy: []domain.SummaryGroup{domain.GroupByMonth},
+			Currency: b.Currency, BaseCurrency: h.cfg.BaseCurrency, Basis: basis,
+		})
+		if err != nil {
+			if errors.Is(err, repo.ErrMissingRate) {
+				return fiber.NewError(http.StatusUnprocessableEntity, "no exchange rate to "+b.Currency+" for some months in the period")
+			}
+			return storeError("budget status", err)
+		}
+		spent := make(map[string]int, len(res.Rows))
+		for _, row := range res.Rows {
+			spent[util.MonthStr(*row.Month)] = row.Total
+		}
+		st := domain.BudgetStatus{Budget: b, Months: make([]domain.BudgetMonth, 0, months)}
+		for m := from; !m.After(to); m = m.AddDate(0, 1, 0) {
+			month := util.MonthStr(m)
+			bm := domain.BudgetMonth{
+				Month:     month,
+				Spent:     spent[month],
+				Limit:     b.Limit,
+				Remaining: b.Limit - spent[month],
+			}
+			bm.OverBudget = bm.Remaining < 0
+			st.OverBudget = st.OverBudget || bm.OverBudget
+			st.Months = append(st.Months, bm)
+		}
+		out.OverBudget = out.OverBudget || st.OverBudget
+		out.Budgets = append(out.Budgets, st)
+	}
+	return c.JSON(out)
+}
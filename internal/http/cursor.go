@@ -0,0 +1,74 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pavel97go/subscriptions/internal/repo"
+)
+
+var errBadCursor = errors.New("invalid cursor")
+
+// cursorCodec turns list positions into opaque tokens signed with
+// HMAC-SHA256, so clients cannot forge or edit them.
+type cursorCodec struct {
+	key []byte
+}
+
+// newCursorCodec uses secret as the signing key, or a random one when it is
+// empty; tokens then stop working after a restart.
+func newCursorCodec(secret string) (cursorCodec, bool) {
+	if secret != "" {
+		return cursorCodec{key: []byte(secret)}, true
+	}
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return cursorCodec{key: key}, false
+}
+
+type cursorPayload struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	Backward  bool      `json:"b,omitempty"`
+}
+
+func (cc cursorCodec) encode(c repo.ListCursor) string {
+	payload, _ := json.Marshal(cursorPayload{CreatedAt: c.CreatedAt, ID: c.ID, Backward: c.Backward})
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(cc.sign(payload))
+}
+
+func (cc cursorCodec) decode(token string) (repo.ListCursor, error) {
+	enc := base64.RawURLEncoding
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return repo.ListCursor{}, errBadCursor
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return repo.ListCursor{}, errBadCursor
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, cc.sign(payload)) {
+		return repo.ListCursor{}, errBadCursor
+	}
+	var c cursorPayload
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return repo.ListCursor{}, errBadCursor
+	}
+	return repo.ListCursor{CreatedAt: c.CreatedAt, ID: c.ID, Backward: c.Backward}, nil
+}
+
+func (cc cursorCodec) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, cc.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+	"github.com/pavel97go/subscriptions/internal/repo"
+	"github.com/pavel97go/subscriptions/internal/util"
+)
+
+const (
+	defaultForecastMonths = 12
+	maxForecastMonths     = 60
+)
+
+// Forecast projects the spend of the next months, starting with the
+// current one, per service and in total. It is a summary over a future
+// period, so end dates and scheduled price changes are honoured and
+// open-ended subscriptions continue through the whole horizon.
+func (h *Handler) Forecast(c *fiber.Ctx) error {
+	var errs validationError
+	months := defaultForecastMonths
+	if v := c.Query("months"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxForecastMonths {
+			errs.add("months", fmt.Sprintf("expected an integer from 1 to %d", maxForecastMonths))
+		}
+		months = n
+	}
+	from := util.MonthStart(time.Now().UTC())
+	if v := c.Query("from"); v != "" {
+		m, err := util.ParseMonth(v)
+		if err != nil {
+			errs.add("from", "expected MM-YYYY")
+		}
+		from = m
+	}
+	var uid *uuid.UUID
+	if v := c.Query("user_id"); v != "" {
+		u, err := uuid.Parse(v)
+		if err != nil {
+			errs.add("user_id", "expected a uuid")
+		}
+		uid = &u
+	}
+	currency := h.cfg.BaseCurrency
+	if v := c.Query("currency"); v != "" {
+		code, ok := domain.NormalizeCurrency(v)
+		if !ok {
+			errs.add("currency", "unsupported currency")
+		}
+		currency = code
+	}
+	basis, err := parseBasis(c.Query("basis"))
+	if err != nil {
+		errs.add("basis", err.Error())
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+	f := repo.SummaryFilter{
+		UserID: uid, From: from, To: from.AddDate(0, months-1, 0),
+		GroupBy:  []domain.SummaryGroup{domain.GroupByService, domain.GroupByMonth},
+		Currency: currency, BaseCurrency: h.cfg.BaseCurrency, Basis: basis,
+	}
+	if v := domain.NormalizeLabel(c.Query("category")); v != "" {
+		f.Category = &v
+	}
+	if v := domain.NormalizeLabel(c.Query("tag")); v != "" {
+		f.Tag = &v
+	}
+	if v := strings.TrimSpace(c.Query("service_name")); v != "" {
+		f.ServiceName = &v
+	}
+	logger.Log.Infof("http forecast: from=%s months=%d user_id=%v currency=%s basis=%s",
+		util.MonthStr(from), months, uid, currency, basis)
+
+	res, err := h.r.Summary(reqCtx(c), f)
+	if err != nil {
+		if errors.Is(err, repo.ErrMissingRate) {
+			return fiber.NewError(http.StatusUnprocessableEntity, "no exchange rate to "+currency+" for some months of the forecast")
+		}
+		return storeError("forecast", err)
+	}
+	return c.JSON(forecastResponse(f, months, res))
+}
+
+// forecastResponse turns summary rows grouped by service and month into
+// zero-filled monthly series, services ordered by name.
+func forecastResponse(f repo.SummaryFilter, months int, res domain.SummaryResult) domain.ForecastResponse {
+	out := domain.ForecastResponse{
+		From:     util.MonthStr(f.From),
+		To:       util.MonthStr(f.To),
+		Currency: res.Currency,
+		Total:    res.Total,
+		Months:   make([]domain.ForecastPoint, months),
+		Services: []domain.ForecastSeries{},
+	}
+	index := make(map[string]int, months)
+	for i := range out.Months {
+		m := util.MonthStr(f.From.AddDate(0, i, 0))
+		out.Months[i].Month = m
+		index[m] = i
+	}
+	series := map[string]int{}
+	for _, row := range res.Rows {
+		j, ok := series[*row.ServiceName]
+		if !ok {
+			j = len(out.Services)
+			series[*row.ServiceName] = j
+			s := domain.ForecastSeries{ServiceName: *row.ServiceName, Months: make([]domain.ForecastPoint, months)}
+			for i := range s.Months {
+				s.Months[i].Month = out.Months[i].Month
+			}
+			out.Services = append(out.Services, s)
+		}
+		i := index[util.MonthStr(*row.Month)]
+		out.Services[j].Months[i].Total += row.Total
+		out.Services[j].Total += row.Total
+		out.Months[i].Total += row.Total
+	}
+	slices.SortFunc(out.Services, func(a, b domain.ForecastSeries) int {
+		return strings.Compare(a.ServiceName, b.ServiceName)
+	})
+	return out
+}
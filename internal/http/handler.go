@@ -2,87 +2,225 @@ package http
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 
+	"github.com/pavel97go/subscriptions/internal/config"
 	"github.com/pavel97go/subscriptions/internal/domain"
 	"github.com/pavel97go/subscriptions/internal/logger"
 	"github.com/pavel97go/subscriptions/internal/repo"
 	"github.com/pavel97go/subscriptions/internal/util"
 )
 
-type Handler struct{ r *repo.Repo }
+type Handler struct {
+	r       repo.Store
+	cfg     *config.Config
+	cursors cursorCodec
+}
+
+func NewHandler(r repo.Store, cfg *config.Config) *Handler {
+	cursors, ok := newCursorCodec(cfg.CursorSecret)
+	if !ok {
+		logger.Log.Warn("cursor_secret is not set, list cursors will not survive a restart")
+	}
+	return &Handler{r: r, cfg: cfg, cursors: cursors}
+}
 
-func NewHandler(r *repo.Repo) *Handler { return &Handler{r: r} }
+// errInvalidBody is returned when the request body cannot be decoded; the
+// decoder's message is not echoed back.
+var errInvalidBody = fiber.NewError(http.StatusBadRequest, "request body is not valid JSON for this endpoint")
+
+const (
+	// actorHeader names the caller recorded in the audit log.
+	actorHeader = "X-Actor"
+	// idempotencyKeyHeader makes POST /subscriptions safe to retry.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed for a repeated key.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// pathID parses the id path parameter.
+func pathID(c *fiber.Ctx) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, invalidField("id", "expected a uuid")
+	}
+	return id, nil
+}
 
 func reqCtx(c *fiber.Ctx) context.Context {
-	if uc := c.UserContext(); uc != nil {
-		return uc
+	ctx := c.UserContext()
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	return context.Background()
+	return repo.WithActor(ctx, strings.TrimSpace(c.Get(actorHeader)))
 }
 
 func (h *Handler) Create(c *fiber.Ctx) error {
 	var in domain.SubscriptionDTO
 	if err := c.BodyParser(&in); err != nil {
-		return fiber.NewError(http.StatusBadRequest, err.Error())
+		return errInvalidBody
+	}
+	s, err := h.fromDTO(in)
+	if err != nil {
+		return err
+	}
+	key := strings.TrimSpace(c.Get(idempotencyKeyHeader))
+	if key == "" {
+		logger.Log.Infof("http create: user_id=%s service=%s", s.UserID, s.ServiceName)
+		id, err := h.r.Create(reqCtx(c), s)
+		if err != nil {
+			return storeError("create", err)
+		}
+		return c.Status(http.StatusCreated).JSON(fiber.Map{"id": id})
 	}
+	if len(key) > 255 {
+		return fiber.NewError(http.StatusBadRequest, idempotencyKeyHeader+" must be at most 255 characters")
+	}
+	logger.Log.Infof("http create: user_id=%s service=%s idempotency_key=%s", s.UserID, s.ServiceName, key)
+	id, replayed, err := h.r.CreateIdempotent(reqCtx(c), repo.IdempotencyKey{
+		Key:         key,
+		RequestHash: requestHash(in),
+		ExpiresAt:   time.Now().Add(h.cfg.IdempotencyTTL),
+	}, s)
+	if err != nil {
+		if errors.Is(err, repo.ErrIdempotencyKeyReused) {
+			return fiber.NewError(http.StatusUnprocessableEntity, idempotencyKeyHeader+" was already used with a different request body")
+		}
+		return storeError("create", err)
+	}
+	if replayed {
+		c.Set(idempotentReplayedHeader, "true")
+	}
+	return c.Status(http.StatusCreated).JSON(fiber.Map{"id": id})
+}
+
+// requestHash fingerprints a create request so that a retry can be told
+// apart from a different request reusing the same Idempotency-Key. The
+// DTO is re-encoded so that formatting of the body does not matter.
+func requestHash(in domain.SubscriptionDTO) string {
+	b, _ := json.Marshal(in)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// fromDTO validates in and converts it to a domain.Subscription,
+// reporting every invalid field at once.
+func (h *Handler) fromDTO(in domain.SubscriptionDTO) (domain.Subscription, error) {
+	var errs validationError
 	in.ServiceName = strings.TrimSpace(in.ServiceName)
 	if in.ServiceName == "" {
-		return fiber.NewError(http.StatusBadRequest, "service_name is required")
+		errs.add("service_name", "is required")
 	}
 	if in.Price < 0 {
-		return fiber.NewError(http.StatusBadRequest, "price must be >= 0")
+		errs.add("price", "must be >= 0")
 	}
-	sm, err := util.ParseMonth(in.StartDate)
-	if err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid start_date, expected MM-YYYY")
+	currency := h.cfg.BaseCurrency
+	if in.Currency != "" {
+		code, ok := domain.NormalizeCurrency(in.Currency)
+		if !ok {
+			errs.add("currency", "unsupported currency")
+		}
+		currency = code
 	}
-	var em *time.Time
-	if in.EndDate != nil && *in.EndDate != "" {
-		t, err := util.ParseMonth(*in.EndDate)
-		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid end_date, expected MM-YYYY")
+	period := domain.BillingMonthly
+	if in.BillingPeriod != "" {
+		period = domain.BillingPeriod(strings.ToLower(strings.TrimSpace(in.BillingPeriod)))
+		if !period.Valid() {
+			errs.add("billing_period", "expected weekly, monthly, quarterly or yearly")
+		}
+	}
+	var category *string
+	if in.Category != nil {
+		if c := domain.NormalizeLabel(*in.Category); c != "" {
+			category = &c
 		}
-		if t.Before(sm) {
-			return fiber.NewError(http.StatusBadRequest, "end_date must be >= start_date")
+		if category != nil && len(*category) > domain.MaxLabelLen {
+			errs.add("category", fmt.Sprintf("must be at most %d characters", domain.MaxLabelLen))
 		}
-		em = &t
 	}
-	s := domain.Subscription{
-		ServiceName: in.ServiceName,
-		Price:       in.Price,
-		UserID:      in.UserID,
-		StartMonth:  sm,
-		EndMonth:    em,
+	tags := domain.NormalizeTags(in.Tags)
+	if len(tags) > domain.MaxTags {
+		errs.add("tags", fmt.Sprintf("at most %d tags allowed", domain.MaxTags))
+	}
+	for _, t := range tags {
+		if len(t) > domain.MaxLabelLen {
+			errs.add("tags", fmt.Sprintf("each tag must be at most %d characters", domain.MaxLabelLen))
+			break
+		}
 	}
-	logger.Log.Infof("http create: user_id=%s service=%s", s.UserID, s.ServiceName)
-	id, err := h.r.Create(reqCtx(c), s)
+	start, startDay, err := util.ParseDate(in.StartDate)
 	if err != nil {
-		logger.Log.Errorf("http create error: %v", err)
-		return fiber.NewError(http.StatusInternalServerError, err.Error())
+		errs.add("start_date", "expected MM-YYYY or YYYY-MM-DD")
 	}
-	return c.Status(http.StatusCreated).JSON(fiber.Map{"id": id})
+	s := domain.Subscription{
+		ServiceName:   in.ServiceName,
+		Price:         in.Price,
+		Currency:      currency,
+		BillingPeriod: period,
+		UserID:        in.UserID,
+		StartMonth:    util.MonthStart(start),
+		Category:      category,
+		Tags:          tags,
+	}
+	if startDay {
+		s.StartDate = &start
+	}
+	if in.TrialUntil != nil && *in.TrialUntil != "" {
+		trial, trialDay, err := util.ParseDate(*in.TrialUntil)
+		if err != nil {
+			errs.add("trial_until", "expected MM-YYYY or YYYY-MM-DD")
+		} else {
+			if !trialDay {
+				// A month means the trial lasts through its last day.
+				trial = trial.AddDate(0, 1, -1)
+			}
+			if !start.IsZero() && trial.Before(s.Anchor()) {
+				errs.add("trial_until", "must be >= start_date")
+			}
+			s.TrialUntil = &trial
+		}
+	}
+	if in.EndDate != nil && *in.EndDate != "" {
+		end, endDay, err := util.ParseDate(*in.EndDate)
+		if err != nil {
+			errs.add("end_date", "expected MM-YYYY or YYYY-MM-DD")
+			return s, errs.err()
+		}
+		em := util.MonthStart(end)
+		if !start.IsZero() && (em.Before(s.StartMonth) || (endDay && end.Before(start))) {
+			errs.add("end_date", "must be >= start_date")
+		}
+		s.EndMonth = &em
+		if endDay {
+			s.EndDate = &end
+		}
+	}
+	return s, errs.err()
 }
 
 func (h *Handler) Get(c *fiber.Ctx) error {
-	id, err := uuid.Parse(c.Params("id"))
+	id, err := pathID(c)
 	if err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid id")
+		return err
 	}
 	s, err := h.r.Get(reqCtx(c), id)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "not found")
-		}
-		logger.Log.Errorf("http get error: %v", err)
-		return fiber.NewError(http.StatusInternalServerError, "internal error")
+		return storeError("get", err)
 	}
+	c.Set(fiber.HeaderETag, etag(s.Version))
 	return c.JSON(toResp(s))
 }
 
@@ -95,113 +233,385 @@ func (h *Handler) List(c *fiber.Ctx) error {
 		offset = v
 	}
 
-	var uid *uuid.UUID
-	if s := c.Query("user_id"); s != "" {
-		u, err := uuid.Parse(s)
+	f, err := parseListFilter(c)
+	if err != nil {
+		return err
+	}
+	envelope := c.QueryBool("envelope")
+	f.CountTotal = envelope
+
+	token := c.Query("cursor")
+	if token != "" || c.Query("pagination") == "cursor" {
+		if c.Query("offset") != "" {
+			return invalidField("offset", "cannot be combined with cursor pagination")
+		}
+		if !f.Sort.IsDefault() {
+			return invalidField("sort", "cursor pagination only supports the default sort")
+		}
+		if token != "" {
+			cur, err := h.cursors.decode(token)
+			if err != nil {
+				return invalidField("cursor", "invalid cursor")
+			}
+			f.Cursor = &cur
+		}
+		return h.listByCursor(c, f, limit)
+	}
+
+	logger.Log.Infof("http list: limit=%d offset=%d filter=%s", limit, offset, c.Context().QueryArgs())
+	items, total, err := h.r.ListFiltered(reqCtx(c), f, limit, offset)
+	if err != nil {
+		return storeError("list", err)
+	}
+	if !envelope {
+		return c.JSON(toRespList(items))
+	}
+
+	page := func(off int) map[string]string {
+		return map[string]string{"offset": strconv.Itoa(off), "limit": strconv.Itoa(limit)}
+	}
+	links := []pageLink{
+		{"first", page(0)},
+		{"last", page(max(total-1, 0) / limit * limit)},
+	}
+	if offset+limit < total {
+		links = append(links, pageLink{"next", page(offset + limit)})
+	}
+	if offset > 0 {
+		links = append(links, pageLink{"prev", page(max(offset-limit, 0))})
+	}
+	setLinks(c, links)
+	return c.JSON(domain.ListResponse{
+		Items: toRespList(items), Total: &total, Limit: limit, Offset: &offset,
+	})
+}
+
+// pageLink is a Link header entry: the current request URL with params
+// replaced.
+type pageLink struct {
+	rel    string
+	params map[string]string
+}
+
+// setLinks sets an RFC 8288 Link header pointing at other pages of the
+// current request.
+func setLinks(c *fiber.Ctx, links []pageLink) {
+	parts := make([]string, 0, len(links))
+	for _, l := range links {
+		q := url.Values{}
+		for k, v := range c.Queries() {
+			q.Set(k, v)
+		}
+		for k, v := range l.params {
+			if v == "" {
+				q.Del(k)
+			} else {
+				q.Set(k, v)
+			}
+		}
+		parts = append(parts, fmt.Sprintf("<%s%s?%s>; rel=%q", c.BaseURL(), c.Path(), q.Encode(), l.rel))
+	}
+	if len(parts) > 0 {
+		c.Set(fiber.HeaderLink, strings.Join(parts, ", "))
+	}
+}
+
+// parseListFilter reads the filter and sort query parameters of List,
+// reporting every invalid one.
+func parseListFilter(c *fiber.Ctx) (repo.ListFilter, error) {
+	f := repo.ListFilter{IncludeDeleted: c.QueryBool("include_deleted")}
+	var errs validationError
+	if v := c.Query("user_id"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			u, err := uuid.Parse(strings.TrimSpace(part))
+			if err != nil {
+				errs.add("user_id", "expected comma-separated uuids")
+				break
+			}
+			f.UserIDs = append(f.UserIDs, u)
+		}
+	}
+	if v := strings.TrimSpace(c.Query("service_name")); v != "" {
+		f.ServiceName = &v
+	}
+	if v := domain.NormalizeLabel(c.Query("category")); v != "" {
+		f.Category = &v
+	}
+	if v := c.Query("tag"); v != "" {
+		f.Tags = domain.NormalizeTags(strings.Split(v, ","))
+	}
+	switch m := repo.ServiceMatch(c.Query("service_match")); m {
+	case "exact":
+	case repo.MatchExact, repo.MatchIExact, repo.MatchPrefix, repo.MatchContains:
+		f.ServiceMatch = m
+	default:
+		errs.add("service_match", "expected exact, iexact, prefix or contains")
+	}
+	for _, p := range []struct {
+		name string
+		dst  **int
+	}{{"price_min", &f.PriceMin}, {"price_max", &f.PriceMax}} {
+		name, dst := p.name, p.dst
+		if v := c.Query(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				errs.add(name, "expected a non-negative integer in minor units")
+				continue
+			}
+			*dst = &n
+		}
+	}
+	if v := c.Query("active_in"); v != "" {
+		m, err := util.ParseMonth(v)
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid user_id")
+			errs.add("active_in", "expected MM-YYYY")
+		} else {
+			f.ActiveIn = &m
 		}
-		uid = &u
 	}
-	var svc *string
-	if s := strings.TrimSpace(c.Query("service_name")); s != "" {
-		svc = &s
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"started_from", &f.StartedFrom}, {"started_to", &f.StartedTo},
+		{"ended_from", &f.EndedFrom}, {"ended_to", &f.EndedTo},
+	} {
+		name, dst := p.name, p.dst
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		d, withDay, err := util.ParseDate(v)
+		if err != nil {
+			errs.add(name, "expected MM-YYYY or YYYY-MM-DD")
+			continue
+		}
+		if !withDay && strings.HasSuffix(name, "_to") {
+			// A month bound includes the whole month.
+			d = d.AddDate(0, 1, -1)
+		}
+		*dst = &d
+	}
+	if v := c.Query("trial_ends_within"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			errs.add("trial_ends_within", "expected a non-negative number of days")
+		} else {
+			today := time.Now().UTC().Truncate(24 * time.Hour)
+			until := today.AddDate(0, 0, n)
+			f.TrialEndsFrom, f.TrialEndsTo = &today, &until
+		}
+	}
+	if v := c.Query("open_ended"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs.add("open_ended", "expected true or false")
+		} else {
+			f.OpenEnded = &b
+		}
+	}
+	if v := c.Query("sort"); v != "" {
+		field, dir, _ := strings.Cut(v, ":")
+		switch repo.ListSortField(field) {
+		case repo.SortCreatedAt, repo.SortPrice, repo.SortStartMonth, repo.SortServiceName:
+			f.Sort.Field = repo.ListSortField(field)
+		default:
+			errs.add("sort", "expected created_at, price, start_month or service_name")
+		}
+		switch dir {
+		case "":
+			// Without a direction a field sorts in its natural order:
+			// newest first for created_at, ascending otherwise.
+			f.Sort.Asc = f.Sort.Field != repo.SortCreatedAt
+		case "asc":
+			f.Sort.Asc = true
+		case "desc":
+		default:
+			errs.add("sort", "direction must be asc or desc")
+		}
 	}
+	return f, errs.err()
+}
 
-	logger.Log.Infof("http list: limit=%d offset=%d user_id=%v service=%v", limit, offset, uid, svc)
-	items, err := h.r.ListFiltered(
-		reqCtx(c),
-		repo.ListFilter{UserID: uid, ServiceName: svc},
-		limit, offset,
-	)
+// listByCursor serves a keyset-paginated page wrapped in a ListResponse.
+// One extra item is fetched to tell whether there is a page beyond it.
+func (h *Handler) listByCursor(c *fiber.Ctx, f repo.ListFilter, limit int) error {
+	logger.Log.Infof("http list: limit=%d cursor=%t filter=%s", limit, f.Cursor != nil, c.Context().QueryArgs())
+	items, total, err := h.r.ListFiltered(reqCtx(c), f, limit+1, 0)
 	if err != nil {
-		logger.Log.Errorf("http list error: %v", err)
-		return fiber.NewError(http.StatusInternalServerError, err.Error())
+		return storeError("list", err)
+	}
+	backward := f.Cursor != nil && f.Cursor.Backward
+	more := len(items) > limit
+	if more {
+		if backward {
+			items = items[1:]
+		} else {
+			items = items[:limit]
+		}
+	}
+	out := domain.ListResponse{Items: toRespList(items), Limit: limit}
+	if f.CountTotal {
+		out.Total = &total
+	}
+	if len(items) > 0 {
+		first, last := items[0], items[len(items)-1]
+		// Paging backward we came from the next page; paging forward from a
+		// cursor we came from the previous one.
+		hasNext, hasPrev := more, f.Cursor != nil
+		if backward {
+			hasNext, hasPrev = true, more
+		}
+		var links []pageLink
+		if hasNext {
+			out.NextCursor = h.cursors.encode(repo.ListCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+			links = append(links, pageLink{"next", map[string]string{"cursor": out.NextCursor, "pagination": ""}})
+		}
+		if hasPrev {
+			out.PrevCursor = h.cursors.encode(repo.ListCursor{CreatedAt: first.CreatedAt, ID: first.ID, Backward: true})
+			links = append(links, pageLink{"prev", map[string]string{"cursor": out.PrevCursor, "pagination": ""}})
+		}
+		setLinks(c, links)
 	}
+	return c.JSON(out)
+}
+
+func toRespList(items []domain.Subscription) []domain.SubscriptionResponse {
 	out := make([]domain.SubscriptionResponse, 0, len(items))
 	for _, s := range items {
 		out = append(out, toResp(s))
 	}
-	return c.JSON(out)
+	return out
 }
 
 func (h *Handler) Update(c *fiber.Ctx) error {
-	id, err := uuid.Parse(c.Params("id"))
+	id, err := pathID(c)
 	if err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid id")
+		return err
 	}
 	var in domain.SubscriptionDTO
 	if err := c.BodyParser(&in); err != nil {
-		return fiber.NewError(http.StatusBadRequest, err.Error())
+		return errInvalidBody
 	}
-	in.ServiceName = strings.TrimSpace(in.ServiceName)
-	if in.ServiceName == "" {
-		return fiber.NewError(http.StatusBadRequest, "service_name is required")
+	s, err := h.fromDTO(in)
+	if err != nil {
+		return err
 	}
-	if in.Price < 0 {
-		return fiber.NewError(http.StatusBadRequest, "price must be >= 0")
+	ifVersion, err := h.ifMatch(c, id)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http update: id=%s user_id=%s service=%s if_version=%d", id, s.UserID, s.ServiceName, ifVersion)
+	if err := h.r.Update(reqCtx(c), id, s, ifVersion); err != nil {
+		return storeError("update", err)
 	}
-	sm, err := util.ParseMonth(in.StartDate)
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// Patch applies a JSON Merge Patch (RFC 7396) to a subscription: omitted
+// fields are kept, null clears optional ones such as end_date. The result
+// goes through the same validation as Create.
+func (h *Handler) Patch(c *fiber.Ctx) error {
+	id, err := pathID(c)
 	if err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid start_date, expected MM-YYYY")
+		return err
 	}
-	var em *time.Time
-	if in.EndDate != nil && *in.EndDate != "" {
-		t, err := util.ParseMonth(*in.EndDate)
-		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid end_date, expected MM-YYYY")
-		}
-		if t.Before(sm) {
-			return fiber.NewError(http.StatusBadRequest, "end_date must be >= start_date")
-		}
-		em = &t
+	switch ct := c.Get(fiber.HeaderContentType); {
+	case strings.HasPrefix(ct, mimeMergePatch), strings.HasPrefix(ct, fiber.MIMEApplicationJSON):
+	default:
+		return fiber.NewError(http.StatusUnsupportedMediaType, "expected "+mimeMergePatch)
 	}
-	s := domain.Subscription{
-		ServiceName: in.ServiceName,
-		Price:       in.Price,
-		UserID:      in.UserID,
-		StartMonth:  sm,
-		EndMonth:    em,
+	ifVersion, err := h.ifMatch(c, id)
+	if err != nil {
+		return err
 	}
-	logger.Log.Infof("http update: id=%s user_id=%s service=%s", id, s.UserID, s.ServiceName)
-	if err := h.r.Update(reqCtx(c), id, s); err != nil {
-		logger.Log.Errorf("http update error: %v", err)
-		return fiber.NewError(http.StatusInternalServerError, err.Error())
+	cur, err := h.r.Get(reqCtx(c), id)
+	if err != nil {
+		return storeError("patch", err)
+	}
+	in, err := applyMergePatch(toDTO(cur), c.Body())
+	if err != nil {
+		return err
+	}
+	s, err := h.fromDTO(in)
+	if err != nil {
+		return err
+	}
+	if ifVersion == 0 {
+		// Guard the read-modify-write against concurrent writers even
+		// when the client sent no If-Match.
+		ifVersion = cur.Version
+	}
+	logger.Log.Infof("http patch: id=%s if_version=%d", id, ifVersion)
+	if err := h.r.Update(reqCtx(c), id, s, ifVersion); err != nil {
+		return storeError("patch", err)
 	}
 	return c.SendStatus(http.StatusNoContent)
 }
 
 func (h *Handler) Delete(c *fiber.Ctx) error {
-	id, err := uuid.Parse(c.Params("id"))
+	id, err := pathID(c)
 	if err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid id")
+		return err
 	}
-	logger.Log.Infof("http delete: id=%s", id)
-	if err := h.r.Delete(reqCtx(c), id); err != nil {
-		logger.Log.Errorf("http delete error: %v", err)
-		return fiber.NewError(http.StatusInternalServerError, err.Error())
+	ifVersion, err := h.ifMatch(c, id)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http delete: id=%s if_version=%d", id, ifVersion)
+	if err := h.r.Delete(reqCtx(c), id, ifVersion); err != nil {
+		return storeError("delete", err)
 	}
 	return c.SendStatus(http.StatusNoContent)
 }
 
+// Restore undoes a soft delete.
+func (h *Handler) Restore(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http restore: id=%s", id)
+	if err := h.r.Restore(reqCtx(c), id); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return fiber.NewError(http.StatusNotFound, "no deleted subscription with this id")
+		}
+		return storeError("restore", err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// Purge permanently removes subscriptions deleted longer than the
+// configured retention ago.
+func (h *Handler) Purge(c *fiber.Ctx) error {
+	cutoff := time.Now().Add(-h.cfg.SoftDeleteRetention)
+	logger.Log.Infof("http purge: cutoff=%s", cutoff.Format(time.RFC3339))
+	n, err := h.r.Purge(reqCtx(c), cutoff)
+	if err != nil {
+		return storeError("purge", err)
+	}
+	return c.JSON(fiber.Map{"purged": n})
+}
+
 func (h *Handler) Summary(c *fiber.Ctx) error {
+	var errs validationError
 	from, err := util.ParseMonth(c.Query("from"))
 	if err != nil {
-		return fiber.NewError(http.StatusBadRequest, "from (MM-YYYY) required")
+		errs.add("from", "required, expected MM-YYYY")
 	}
-	to, err := util.ParseMonth(c.Query("to"))
-	if err != nil {
-		return fiber.NewError(http.StatusBadRequest, "to (MM-YYYY) required")
+	to, errTo := util.ParseMonth(c.Query("to"))
+	if errTo != nil {
+		errs.add("to", "required, expected MM-YYYY")
 	}
-	if to.Before(from) {
-		return fiber.NewError(http.StatusBadRequest, "`to` must be >= `from`")
+	if err == nil && errTo == nil && to.Before(from) {
+		errs.add("to", "must be >= from")
 	}
 
 	var uid *uuid.UUID
 	if s := c.Query("user_id"); s != "" {
 		u, err := uuid.Parse(s)
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid user_id")
+			errs.add("user_id", "expected a uuid")
 		}
 		uid = &u
 	}
@@ -210,6 +620,32 @@ func (h *Handler) Summary(c *fiber.Ctx) error {
 	if s := strings.TrimSpace(c.Query("service_name")); s != "" {
 		svc = &s
 	}
+	var category, tag *string
+	if s := domain.NormalizeLabel(c.Query("category")); s != "" {
+		category = &s
+	}
+	if s := domain.NormalizeLabel(c.Query("tag")); s != "" {
+		tag = &s
+	}
+	groupBy, err := parseGroupBy(c.Query("group_by"))
+	if err != nil {
+		errs.add("group_by", err.Error())
+	}
+	currency := h.cfg.BaseCurrency
+	if s := c.Query("currency"); s != "" {
+		code, ok := domain.NormalizeCurrency(s)
+		if !ok {
+			errs.add("currency", "unsupported currency")
+		}
+		currency = code
+	}
+	basis, err := parseBasis(c.Query("basis"))
+	if err != nil {
+		errs.add("basis", err.Error())
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
 	var svcLog, uidLog string
 	if svc != nil {
 		svcLog = *svc
@@ -222,34 +658,336 @@ func (h *Handler) Summary(c *fiber.Ctx) error {
 		uidLog = "<none>"
 	}
 
-	logger.Log.Infof("http summary: from=%s to=%s user_id=%s service=%s",
-		util.MonthStr(from), util.MonthStr(to), uidLog, svcLog)
+	logger.Log.Infof("http summary: from=%s to=%s user_id=%s service=%s group_by=%v currency=%s basis=%s",
+		util.MonthStr(from), util.MonthStr(to), uidLog, svcLog, groupBy, currency, basis)
 
-	total, err := h.r.Summary(
+	res, err := h.r.Summary(
 		reqCtx(c),
-		repo.SummaryFilter{UserID: uid, ServiceName: svc, From: from, To: to},
+		repo.SummaryFilter{
+			UserID: uid, ServiceName: svc, Category: category, Tag: tag, From: from, To: to, GroupBy: groupBy,
+			Currency: currency, BaseCurrency: h.cfg.BaseCurrency, Basis: basis,
+			IncludeDeleted: c.QueryBool("include_deleted"),
+		},
 	)
 	if err != nil {
-		logger.Log.Errorf("http summary error: %v", err)
-		return fiber.NewError(http.StatusInternalServerError, err.Error())
+		if errors.Is(err, repo.ErrMissingRate) {
+			return fiber.NewError(http.StatusUnprocessableEntity, "no exchange rate to "+currency+" for some months in the period")
+		}
+		return storeError("summary", err)
+	}
+	if len(groupBy) == 0 {
+		return c.JSON(fiber.Map{"total": res.Total, "currency": res.Currency})
+	}
+
+	out := domain.SummaryResponse{Total: res.Total, Currency: res.Currency, Items: make([]domain.SummaryItemResponse, 0, len(res.Rows))}
+	for _, row := range res.Rows {
+		item := domain.SummaryItemResponse{
+			ServiceName: row.ServiceName, UserID: row.UserID, Category: row.Category, Tag: row.Tag, Total: row.Total,
+		}
+		if row.Month != nil {
+			m := util.MonthStr(*row.Month)
+			item.Month = &m
+		}
+		out.Items = append(out.Items, item)
+	}
+	return c.JSON(out)
+}
+
+// parseBasis parses the basis query parameter, defaulting to charges.
+func parseBasis(s string) (domain.SummaryBasis, error) {
+	if s == "" {
+		return domain.BasisCharges, nil
 	}
+	switch b := domain.SummaryBasis(s); b {
+	case domain.BasisCharges, domain.BasisMonthly, domain.BasisProrated:
+		return b, nil
+	}
+	return "", errors.New("expected charges, monthly_equivalent or prorated")
+}
 
-	return c.JSON(fiber.Map{"total": total})
+// parseGroupBy parses a comma-separated group_by value such as
+// "service_name,month".
+func parseGroupBy(s string) ([]domain.SummaryGroup, error) {
+	var out []domain.SummaryGroup
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		g := domain.SummaryGroup(part)
+		switch g {
+		case domain.GroupByService, domain.GroupByUser, domain.GroupByMonth, domain.GroupByCategory, domain.GroupByTag:
+		default:
+			return nil, fmt.Errorf("unknown group %q, expected service_name, user_id, month, category or tag", part)
+		}
+		if !slices.Contains(out, g) {
+			out = append(out, g)
+		}
+	}
+	return out, nil
+}
+
+// toDTO is the inverse of fromDTO, used as the base document for PATCH.
+func toDTO(s domain.Subscription) domain.SubscriptionDTO {
+	r := toResp(s)
+	return domain.SubscriptionDTO{
+		ServiceName:   r.ServiceName,
+		Price:         r.Price,
+		Currency:      r.Currency,
+		BillingPeriod: r.BillingPeriod,
+		UserID:        r.UserID,
+		StartDate:     r.StartDate,
+		EndDate:       r.EndDate,
+		TrialUntil:    r.TrialUntil,
+		Category:      r.Category,
+		Tags:          r.Tags,
+	}
 }
 
 func toResp(s domain.Subscription) domain.SubscriptionResponse {
 	out := domain.SubscriptionResponse{
-		ID:          s.ID,
-		ServiceName: s.ServiceName,
-		Price:       s.Price,
-		UserID:      s.UserID,
-		StartDate:   util.MonthStr(s.StartMonth),
-		CreatedAt:   s.CreatedAt,
-		UpdatedAt:   s.UpdatedAt,
-	}
-	if s.EndMonth != nil {
+		ID:            s.ID,
+		ServiceName:   s.ServiceName,
+		ServiceID:     s.ServiceID,
+		Price:         s.Price,
+		Currency:      s.Currency,
+		BillingPeriod: string(s.BillingPeriod),
+		UserID:        s.UserID,
+		StartDate:     util.MonthStr(s.StartMonth),
+		CreatedAt:     s.CreatedAt,
+		UpdatedAt:     s.UpdatedAt,
+		Category:      s.Category,
+		Tags:          s.Tags,
+		DeletedAt:     s.DeletedAt,
+		ETag:          etag(s.Version),
+	}
+	if out.Tags == nil {
+		out.Tags = []string{}
+	}
+	if s.StartDate != nil {
+		out.StartDate = util.DateStr(*s.StartDate)
+	}
+	if s.EndDate != nil {
+		e := util.DateStr(*s.EndDate)
+		out.EndDate = &e
+	} else if s.EndMonth != nil {
 		e := util.MonthStr(*s.EndMonth)
 		out.EndDate = &e
 	}
+	if s.TrialUntil != nil {
+		t := util.DateStr(*s.TrialUntil)
+		out.TrialUntil = &t
+	}
 	return out
 }
+
+func (h *Handler) ListRates(c *fiber.Ctx) error {
+	rates, err := h.r.ListRates(reqCtx(c))
+	if err != nil {
+		return storeError("list rates", err)
+	}
+	out := make([]domain.ExchangeRateDTO, 0, len(rates))
+	for _, rt := range rates {
+		out = append(out, domain.ExchangeRateDTO{Currency: rt.Currency, Month: util.MonthStr(rt.Month), Rate: rt.Rate})
+	}
+	return c.JSON(out)
+}
+
+// PutRates inserts or replaces the given exchange rates.
+func (h *Handler) PutRates(c *fiber.Ctx) error {
+	var in []domain.ExchangeRateDTO
+	if err := c.BodyParser(&in); err != nil {
+		return errInvalidBody
+	}
+	rates := make([]domain.ExchangeRate, 0, len(in))
+	var errs validationError
+	for i, d := range in {
+		rt, err := d.ToRate()
+		if err != nil {
+			errs.add(fmt.Sprintf("[%d]", i), err.Error())
+			continue
+		}
+		rates = append(rates, rt)
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+	logger.Log.Infof("http put rates: count=%d", len(rates))
+	if err := h.r.UpsertRates(reqCtx(c), rates); err != nil {
+		return storeError("put rates", err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// SchedulePrice records a price change taking effect from the given month.
+func (h *Handler) SchedulePrice(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	var in domain.PriceChangeDTO
+	if err := c.BodyParser(&in); err != nil {
+		return errInvalidBody
+	}
+	var errs validationError
+	if in.Price < 0 {
+		errs.add("price", "must be >= 0")
+	}
+	from, err := util.ParseMonth(in.EffectiveFrom)
+	if err != nil {
+		errs.add("effective_from", "expected MM-YYYY")
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+	s, err := h.r.Get(reqCtx(c), id)
+	if err != nil {
+		return storeError("schedule price", err)
+	}
+	if from.Before(s.StartMonth) || (s.EndMonth != nil && from.After(*s.EndMonth)) {
+		return invalidField("effective_from", "must be within the subscription period")
+	}
+	logger.Log.Infof("http schedule price: id=%s price=%d from=%s", id, in.Price, util.MonthStr(from))
+	err = h.r.SchedulePriceChange(reqCtx(c), domain.PriceChange{SubscriptionID: id, EffectiveFrom: from, Price: in.Price})
+	if err != nil {
+		return storeError("schedule price", err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+func (h *Handler) ListPrices(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.r.Get(reqCtx(c), id); err != nil {
+		return storeError("list prices", err)
+	}
+	changes, err := h.r.ListPriceChanges(reqCtx(c), id)
+	if err != nil {
+		return storeError("list prices", err)
+	}
+	out := make([]domain.PriceChangeResponse, 0, len(changes))
+	for _, pc := range changes {
+		out = append(out, domain.PriceChangeResponse{
+			Price:         pc.Price,
+			EffectiveFrom: util.MonthStr(pc.EffectiveFrom),
+			CreatedAt:     pc.CreatedAt,
+		})
+	}
+	return c.JSON(out)
+}
+
+// History returns the audit events of a subscription, oldest first. It
+// keeps working after the subscription is deleted.
+func (h *Handler) History(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	events, err := h.r.History(reqCtx(c), id)
+	if err != nil {
+		return storeError("history", err)
+	}
+	if len(events) == 0 {
+		if _, err := h.r.Get(reqCtx(c), id); err != nil {
+			return storeError("history", err)
+		}
+		events = []domain.SubscriptionEvent{}
+	}
+	return c.JSON(events)
+}
+
+// etag renders a subscription version as a strong entity tag.
+func etag(version int) string { return fmt.Sprintf("%q", strconv.Itoa(version)) }
+
+// ifMatch returns the version of subscription id required by the If-Match
+// header, or 0 when the header is absent or "*".
+func (h *Handler) ifMatch(c *fiber.Ctx, id uuid.UUID) (int, error) {
+	return h.matchVersion(reqCtx(c), id, c.Get(fiber.HeaderIfMatch))
+}
+
+// matchVersion resolves an If-Match value v for subscription id. A list of
+// several tags is matched against the current version, which the store then
+// checks again. A missing value is rejected with 428 when the config
+// requires it.
+func (h *Handler) matchVersion(ctx context.Context, id uuid.UUID, v string) (int, error) {
+	versions, err := h.parseIfMatch(v)
+	switch {
+	case err != nil:
+		return 0, err
+	case len(versions) == 0:
+		return 0, nil
+	case len(versions) == 1:
+		return versions[0], nil
+	}
+	s, err := h.r.Get(ctx, id)
+	if err != nil {
+		return 0, storeError("if-match", err)
+	}
+	if !slices.Contains(versions, s.Version) {
+		return 0, errPreconditionFailed
+	}
+	return s.Version, nil
+}
+
+var errPreconditionFailed = fiber.NewError(http.StatusPreconditionFailed, "subscription was modified, re-fetch it")
+
+// parseIfMatch returns the versions listed in an If-Match value, nil for
+// "*" or a missing value. Weak tags never match under the strong
+// comparison If-Match uses, so a list of only weak tags fails with 412.
+func (h *Handler) parseIfMatch(v string) ([]int, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		if h.cfg.RequireIfMatch {
+			return nil, fiber.NewError(http.StatusPreconditionRequired, "If-Match header required")
+		}
+		return nil, nil
+	}
+	if v == "*" {
+		return nil, nil
+	}
+	var versions []int
+	for tag := range strings.SplitSeq(v, ",") {
+		tag, weak := strings.CutPrefix(strings.TrimSpace(tag), "W/")
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			return nil, fiber.NewError(http.StatusBadRequest, "invalid If-Match")
+		}
+		n, err := strconv.Atoi(tag[1 : len(tag)-1])
+		if err != nil || n <= 0 {
+			return nil, fiber.NewError(http.StatusBadRequest, "invalid If-Match")
+		}
+		if !weak {
+			versions = append(versions, n)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, errPreconditionFailed
+	}
+	return versions, nil
+}
+
+// storeError maps a Store error to the HTTP status it stands for, logging
+// anything unexpected as an internal error of op.
+func storeError(op string, err error) error {
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		return fiber.NewError(http.StatusNotFound, "not found")
+	case errors.Is(err, repo.ErrPriceChange):
+		return invalidField("price", "cannot be changed in place, schedule it via POST /subscriptions/{id}/prices")
+	case errors.Is(err, repo.ErrCurrencyChange):
+		return invalidField("currency", "cannot be changed in place, create a new subscription")
+	case errors.Is(err, repo.ErrBillingPeriodChange):
+		return invalidField("billing_period", "cannot be changed in place, create a new subscription")
+	case errors.Is(err, repo.ErrStartChange):
+		return invalidField("start_date", "cannot be changed in place, create a new subscription")
+	case errors.Is(err, repo.ErrVersionMismatch):
+		return errPreconditionFailed
+	case errors.Is(err, repo.ErrConflict):
+		return fiber.NewError(http.StatusConflict, "conflicts with existing data")
+	case errors.Is(err, repo.ErrValidation):
+		return fiber.NewError(http.StatusUnprocessableEntity, "rejected by data constraints")
+	}
+	logger.Log.Errorf("http %s error: %v", op, err)
+	return fiber.NewError(http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+)
+
+// mimeMergePatch is the content type of RFC 7396 JSON Merge Patch bodies.
+const mimeMergePatch = "application/merge-patch+json"
+
+// requiredFields may be changed by a merge patch but not removed with null.
+var requiredFields = []string{"service_name", "price", "user_id", "start_date"}
+
+// applyMergePatch applies patch to in following RFC 7396.
+func applyMergePatch(in domain.SubscriptionDTO, patch []byte) (domain.SubscriptionDTO, error) {
+	var p map[string]any
+	if err := json.Unmarshal(patch, &p); err != nil || p == nil {
+		return in, fiber.NewError(http.StatusBadRequest, "merge patch must be a JSON object")
+	}
+	var errs validationError
+	for _, f := range requiredFields {
+		if v, ok := p[f]; ok && v == nil {
+			errs.add(f, "cannot be removed")
+		}
+	}
+	if err := errs.err(); err != nil {
+		return in, err
+	}
+
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return in, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return in, err
+	}
+	raw, err = json.Marshal(mergePatch(doc, p))
+	if err != nil {
+		return in, err
+	}
+	var out domain.SubscriptionDTO
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return in, errInvalidBody
+	}
+	return out, nil
+}
+
+// mergePatch merges patch into target: null removes a member, objects are
+// merged recursively and any other value replaces the target's.
+func mergePatch(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = map[string]any{}
+	}
+	for k, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(target, k)
+		case map[string]any:
+			tv, _ := target[k].(map[string]any)
+			target[k] = mergePatch(tv, pv)
+		default:
+			target[k] = v
+		}
+	}
+	return target
+}
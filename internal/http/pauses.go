@@ -0,0 +1,119 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+	"github.com/pavel97go/subscriptions/internal/repo"
+	"github.com/pavel97go/subscriptions/internal/util"
+)
+
+// Pause suspends charging of a subscription from the given month (the
+// current one by default) until the given month inclusive, or until it is
+// resumed.
+func (h *Handler) Pause(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	var in domain.PauseDTO
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&in); err != nil {
+			return errInvalidBody
+		}
+	}
+	var errs validationError
+	p := domain.Pause{SubscriptionID: id, From: util.MonthStart(time.Now().UTC())}
+	if in.From != "" {
+		if p.From, err = util.ParseMonth(in.From); err != nil {
+			errs.add("from", "expected MM-YYYY")
+		}
+	}
+	if in.Until != nil {
+		until, err := util.ParseMonth(*in.Until)
+		switch {
+		case err != nil:
+			errs.add("until", "expected MM-YYYY")
+		case until.Before(p.From):
+			errs.add("until", "must be >= from")
+		default:
+			p.Until = &until
+		}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+	s, err := h.r.Get(reqCtx(c), id)
+	if err != nil {
+		return storeError("pause", err)
+	}
+	if p.From.Before(s.StartMonth) || (s.EndMonth != nil && p.From.After(*s.EndMonth)) {
+		return invalidField("from", "must be within the subscription period")
+	}
+	logger.Log.Infof("http pause: id=%s from=%s", id, util.MonthStr(p.From))
+	if err := h.r.Pause(reqCtx(c), p); err != nil {
+		if errors.Is(err, repo.ErrPauseOverlap) {
+			return fiber.NewError(http.StatusConflict, "overlaps an existing pause")
+		}
+		return storeError("pause", err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// Resume ends the pause covering the given month (the current one by
+// default), so the subscription is charged again from that month.
+func (h *Handler) Resume(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	var in domain.ResumeDTO
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&in); err != nil {
+			return errInvalidBody
+		}
+	}
+	month := util.MonthStart(time.Now().UTC())
+	if in.From != "" {
+		if month, err = util.ParseMonth(in.From); err != nil {
+			return invalidField("from", "expected MM-YYYY")
+		}
+	}
+	logger.Log.Infof("http resume: id=%s from=%s", id, util.MonthStr(month))
+	if err := h.r.Resume(reqCtx(c), id, month); err != nil {
+		if errors.Is(err, repo.ErrNotPaused) {
+			return fiber.NewError(http.StatusConflict, "subscription is not paused in this month")
+		}
+		return storeError("resume", err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+func (h *Handler) ListPauses(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.r.Get(reqCtx(c), id); err != nil {
+		return storeError("list pauses", err)
+	}
+	pauses, err := h.r.ListPauses(reqCtx(c), id)
+	if err != nil {
+		return storeError("list pauses", err)
+	}
+	out := make([]domain.PauseResponse, 0, len(pauses))
+	for _, p := range pauses {
+		r := domain.PauseResponse{From: util.MonthStr(p.From), CreatedAt: p.CreatedAt}
+		if p.Until != nil {
+			until := util.MonthStr(*p.Until)
+			r.Until = &until
+		}
+		out = append(out, r)
+	}
+	return c.JSON(out)
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/pavel97go/subscriptions/internal/logger"
+)
+
+// MIMEProblemJSON is the content type of RFC 7807 error responses.
+const MIMEProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError carries every field error found in a request.
+type validationError []FieldError
+
+func (v validationError) Error() string { return "validation failed" }
+
+// add records a field error; nil-safe so callers can start from a zero value.
+func (v *validationError) add(field, message string) {
+	*v = append(*v, FieldError{Field: field, Message: message})
+}
+
+// err returns v as an error, or nil when no field was rejected.
+func (v validationError) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// invalidField is a shorthand for a validation error on a single field.
+func invalidField(field, message string) error {
+	return validationError{{Field: field, Message: message}}
+}
+
+// ErrorHandler renders every error returned by a handler as
+// application/problem+json.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	p := newProblem(err, c.OriginalURL())
+	if fe := (*fiber.Error)(nil); p.Status == http.StatusInternalServerError && !errors.As(err, &fe) {
+		logger.Log.Errorf("http %s %s unhandled error: %v", c.Method(), c.Path(), err)
+	}
+	return c.Status(p.Status).JSON(p, MIMEProblemJSON)
+}
+
+// newProblem describes err as a Problem. Errors other than *fiber.Error
+// and validation errors become a bare 500 so internal details never reach
+// the client.
+func newProblem(err error, instance string) Problem {
+	p := Problem{Type: "about:blank", Status: http.StatusInternalServerError, Instance: instance}
+	var ve validationError
+	var fe *fiber.Error
+	switch {
+	case errors.As(err, &ve):
+		p.Status = http.StatusBadRequest
+		p.Detail = "request has invalid fields"
+		p.Errors = ve
+	case errors.As(err, &fe):
+		p.Status = fe.Code
+		p.Detail = fe.Message
+	}
+	if p.Status >= http.StatusInternalServerError {
+		p.Detail = ""
+	}
+	p.Title = http.StatusText(p.Status)
+	if p.Detail == p.Title {
+		p.Detail = ""
+	}
+	return p
+}
@@ -10,13 +10,46 @@ func Setup(app *fiber.App, h *Handler) {
 
 	app.Use(logger.New())
 
+	// The colon is escaped so that ":batch" is not taken for a parameter.
+	app.Post(`/subscriptions\:batch`, h.Batch)
+
 	api := app.Group("/subscriptions")
 
 	api.Get("/", h.List)
 	api.Get("/summary", h.Summary)
+	api.Get("/forecast", h.Forecast)
 
 	api.Post("/", h.Create)
 	api.Get("/:id", h.Get)
 	api.Put("/:id", h.Update)
+	api.Patch("/:id", h.Patch)
 	api.Delete("/:id", h.Delete)
+	api.Get("/:id/prices", h.ListPrices)
+	api.Post("/:id/prices", h.SchedulePrice)
+	api.Get("/:id/pauses", h.ListPauses)
+	api.Post("/:id/pause", h.Pause)
+	api.Post("/:id/resume", h.Resume)
+	api.Get("/:id/history", h.History)
+	api.Post("/:id/restore", h.Restore)
+
+	services := app.Group("/services")
+	services.Get("/", h.ListServices)
+	services.Post("/", h.CreateService)
+	services.Get("/:id", h.GetService)
+	services.Put("/:id", h.UpdateService)
+	services.Delete("/:id", h.DeleteService)
+
+	budgets := app.Group("/budgets")
+	budgets.Get("/", h.ListBudgets)
+	budgets.Post("/", h.CreateBudget)
+	budgets.Get("/:id", h.GetBudget)
+	budgets.Put("/:id", h.UpdateBudget)
+	budgets.Delete("/:id", h.DeleteBudget)
+
+	app.Get("/users/:id/budget-status", h.BudgetStatus)
+
+	admin := app.Group("/admin")
+	admin.Get("/exchange-rates", h.ListRates)
+	admin.Put("/exchange-rates", h.PutRates)
+	admin.Post("/purge", h.Purge)
 }
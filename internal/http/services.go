@@ -0,0 +1,146 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+	"github.com/pavel97go/subscriptions/internal/repo"
+)
+
+func (h *Handler) ListServices(c *fiber.Ctx) error {
+	out, err := h.r.ListServices(reqCtx(c))
+	if err != nil {
+		return storeError("list services", err)
+	}
+	if out == nil {
+		out = []domain.Service{}
+	}
+	return c.JSON(out)
+}
+
+func (h *Handler) GetService(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	sv, err := h.r.GetService(reqCtx(c), id)
+	if err != nil {
+		return storeError("get service", err)
+	}
+	return c.JSON(sv)
+}
+
+// CreateService adds a catalog entry and links the existing subscriptions
+// whose service_name matches its name or an alias.
+func (h *Handler) CreateService(c *fiber.Ctx) error {
+	var in domain.ServiceDTO
+	if err := c.BodyParser(&in); err != nil {
+		return errInvalidBody
+	}
+	sv, err := h.serviceFromDTO(in)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http create service: name=%s aliases=%d", sv.Name, len(sv.Aliases))
+	created, err := h.r.CreateService(reqCtx(c), sv)
+	if err != nil {
+		return serviceStoreError("create service", err)
+	}
+	return c.Status(http.StatusCreated).JSON(created)
+}
+
+func (h *Handler) UpdateService(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	var in domain.ServiceDTO
+	if err := c.BodyParser(&in); err != nil {
+		return errInvalidBody
+	}
+	sv, err := h.serviceFromDTO(in)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http update service: id=%s name=%s aliases=%d", id, sv.Name, len(sv.Aliases))
+	updated, err := h.r.UpdateService(reqCtx(c), id, sv)
+	if err != nil {
+		return serviceStoreError("update service", err)
+	}
+	return c.JSON(updated)
+}
+
+func (h *Handler) DeleteService(c *fiber.Ctx) error {
+	id, err := pathID(c)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("http delete service: id=%s", id)
+	if err := h.r.DeleteService(reqCtx(c), id); err != nil {
+		return storeError("delete service", err)
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// serviceFromDTO validates in and converts it to a domain.Service,
+// reporting every invalid field at once.
+func (h *Handler) serviceFromDTO(in domain.ServiceDTO) (domain.Service, error) {
+	var errs validationError
+	sv := domain.Service{
+		Name:         strings.TrimSpace(in.Name),
+		Aliases:      []string{},
+		DefaultPrice: in.DefaultPrice,
+		Currency:     h.cfg.BaseCurrency,
+	}
+	if sv.Name == "" {
+		errs.add("name", "is required")
+	}
+	for _, a := range in.Aliases {
+		if a = strings.TrimSpace(a); a != "" {
+			sv.Aliases = append(sv.Aliases, a)
+		}
+	}
+	if in.Category != nil {
+		if cat := domain.NormalizeLabel(*in.Category); cat != "" {
+			sv.Category = &cat
+		}
+		if sv.Category != nil && len(*sv.Category) > domain.MaxLabelLen {
+			errs.add("category", fmt.Sprintf("must be at most %d characters", domain.MaxLabelLen))
+		}
+	}
+	if in.DefaultPrice != nil && *in.DefaultPrice < 0 {
+		errs.add("default_price", "must be >= 0")
+	}
+	if in.Currency != "" {
+		code, ok := domain.NormalizeCurrency(in.Currency)
+		if !ok {
+			errs.add("currency", "unsupported currency")
+		}
+		sv.Currency = code
+	}
+	if in.VendorURL != nil && strings.TrimSpace(*in.VendorURL) != "" {
+		raw := strings.TrimSpace(*in.VendorURL)
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("vendor_url", "expected an absolute http(s) URL")
+		}
+		sv.VendorURL = &raw
+	}
+	return sv, errs.err()
+}
+
+// serviceStoreError is storeError with a clearer message for names and
+// aliases already taken by another service.
+func serviceStoreError(op string, err error) error {
+	if errors.Is(err, repo.ErrConflict) {
+		return fiber.NewError(http.StatusConflict, "name or alias already belongs to another service")
+	}
+	return storeError(op, err)
+}
@@ -0,0 +1,29 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context whose mutations are attributed to actor in
+// the audit log.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if a, ok := ctx.Value(actorKey{}).(string); ok && a != "" {
+		return a
+	}
+	return "anonymous"
+}
+
+// snapshot marshals v for an audit event; a nil v yields SQL/JSON null.
+func snapshot(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,945 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+)
+
+// Memory is an in-process Store with the same semantics as Repo.
+// Data lives only for the lifetime of the process.
+type Memory struct {
+	mu     sync.RWMutex
+	subs   map[uuid.UUID]domain.Subscription
+	rates  map[string][]domain.ExchangeRate   // per currency, ordered by month
+	prices map[uuid.UUID][]domain.PriceChange // per subscription, ordered by month
+	pauses map[uuid.UUID][]domain.Pause       // per subscription, ordered by month
+	events []domain.SubscriptionEvent
+	idem   map[string]memIdempotency
+	// services is the catalog; serviceKeys maps every normalized name and
+	// alias to its service.
+	services    map[uuid.UUID]domain.Service
+	serviceKeys map[string]uuid.UUID
+	budgets     map[uuid.UUID]domain.Budget
+}
+
+type memIdempotency struct {
+	IdempotencyKey
+	id uuid.UUID
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		subs:   make(map[uuid.UUID]domain.Subscription),
+		rates:  make(map[string][]domain.ExchangeRate),
+		prices: make(map[uuid.UUID][]domain.PriceChange),
+		pauses: make(map[uuid.UUID][]domain.Pause),
+		idem:   make(map[string]memIdempotency),
+
+		services:    make(map[uuid.UUID]domain.Service),
+		serviceKeys: make(map[string]uuid.UUID),
+		budgets:     make(map[uuid.UUID]domain.Budget),
+	}
+}
+
+func (m *Memory) Close() {}
+
+func (m *Memory) Create(ctx context.Context, s domain.Subscription) (uuid.UUID, error) {
+	logger.Log.Infof("creating subscription: user_id=%s, service=%s", s.UserID, s.ServiceName)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	created, err := m.createLocked(ctx, s)
+	return created.ID, err
+}
+
+func (m *Memory) CreateIdempotent(ctx context.Context, key IdempotencyKey, s domain.Subscription) (uuid.UUID, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if prev, ok := m.idem[key.Key]; ok && time.Now().Before(prev.ExpiresAt) {
+		if prev.RequestHash != key.RequestHash {
+			return uuid.Nil, false, ErrIdempotencyKeyReused
+		}
+		return prev.id, true, nil
+	}
+	created, err := m.createLocked(ctx, s)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	m.idem[key.Key] = memIdempotency{IdempotencyKey: key, id: created.ID}
+	return created.ID, false, nil
+}
+
+func (m *Memory) Get(_ context.Context, id uuid.UUID) (domain.Subscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.subs[id]
+	if !ok || s.DeletedAt != nil {
+		return domain.Subscription{}, ErrNotFound
+	}
+	return cloneSub(s), nil
+}
+
+func (m *Memory) ListFiltered(_ context.Context, f ListFilter, limit, offset int) ([]domain.Subscription, int, error) {
+	m.mu.RLock()
+	var out []domain.Subscription
+	for _, s := range m.subs {
+		if f.matches(s) {
+			out = append(out, cloneSub(s))
+		}
+	}
+	m.mu.RUnlock()
+	total := 0
+	if f.CountTotal {
+		total = len(out)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		c := f.Sort.compare(out[i], out[j])
+		if c == 0 {
+			c = strings.Compare(out[i].ID.String(), out[j].ID.String())
+		}
+		if f.Sort.Asc {
+			return c < 0
+		}
+		return c > 0
+	})
+	if c := f.Cursor; c != nil {
+		// Items before the cursor in list order come first.
+		pos := sort.Search(len(out), func(i int) bool {
+			s := out[i]
+			return s.CreatedAt.Before(c.CreatedAt) ||
+				(s.CreatedAt.Equal(c.CreatedAt) && s.ID.String() <= c.ID.String())
+		})
+		if !c.Backward {
+			if pos < len(out) && out[pos].ID == c.ID {
+				pos++
+			}
+			out, offset = out[pos:], 0
+		} else {
+			out, offset = out[:pos], max(pos-limit, 0)
+		}
+	}
+	if offset >= len(out) {
+		return nil, total, nil
+	}
+	out = out[offset:]
+	if limit < len(out) {
+		out = out[:limit]
+	}
+	return out, total, nil
+}
+
+func (m *Memory) Update(ctx context.Context, id uuid.UUID, s domain.Subscription, ifVersion int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.updateLocked(ctx, id, s, ifVersion)
+	return err
+}
+
+func (m *Memory) Delete(ctx context.Context, id uuid.UUID, ifVersion int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.deleteLocked(ctx, id, ifVersion)
+	return err
+}
+
+// Batch mirrors Repo.Batch. Atomic batches are rolled back by restoring
+// the subscriptions and events as they were before the batch.
+func (m *Memory) Batch(ctx context.Context, ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var subs map[uuid.UUID]domain.Subscription
+	if atomic {
+		subs = maps.Clone(m.subs)
+	}
+	events := len(m.events)
+	res := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		var err error
+		switch op.Action {
+		case BatchCreate:
+			res[i].Sub, err = m.createLocked(ctx, op.Sub)
+		case BatchUpdate:
+			res[i].Sub, err = m.updateLocked(ctx, op.ID, op.Sub, op.IfVersion)
+		case BatchDelete:
+			res[i].Sub, err = m.deleteLocked(ctx, op.ID, op.IfVersion)
+		default:
+			err = fmt.Errorf("%w: unknown batch action %q", ErrValidation, op.Action)
+		}
+		if err == nil {
+			continue
+		}
+		if !atomic {
+			res[i] = BatchResult{Err: err}
+			continue
+		}
+		m.subs = subs
+		m.events = m.events[:events]
+		for j := range res {
+			res[j] = BatchResult{Err: ErrBatchAborted}
+		}
+		res[i].Err = err
+		return res, nil
+	}
+	return res, nil
+}
+
+// createLocked, updateLocked and deleteLocked implement the mutations and
+// return the stored state afterwards. Callers must hold m.mu for writing.
+func (m *Memory) createLocked(ctx context.Context, s domain.Subscription) (domain.Subscription, error) {
+	now := time.Now()
+	s.ID = uuid.New()
+	s = cloneSub(s)
+	m.resolveServiceLocked(&s)
+	s.CreatedAt = now
+	s.UpdatedAt = now
+	s.Version = 1
+	if err := m.addEvent(ctx, s.ID, domain.EventCreate, nil, s); err != nil {
+		return domain.Subscription{}, err
+	}
+	m.subs[s.ID] = s
+	return cloneSub(s), nil
+}
+
+func (m *Memory) updateLocked(ctx context.Context, id uuid.UUID, s domain.Subscription, ifVersion int) (domain.Subscription, error) {
+	cur, ok := m.subs[id]
+	if !ok || cur.DeletedAt != nil {
+		return domain.Subscription{}, ErrNotFound
+	}
+	if ifVersion != 0 && cur.Version != ifVersion {
+		return domain.Subscription{}, ErrVersionMismatch
+	}
+	if err := checkFixedTerms(cur, s); err != nil {
+		return domain.Subscription{}, err
+	}
+	before := cur
+	m.resolveServiceLocked(&s)
+	cur.ServiceName = s.ServiceName
+	cur.ServiceID = s.ServiceID
+	cur.Category = cloneString(s.Category)
+	cur.Tags = cloneTags(s.Tags)
+	cur.Currency = s.Currency
+	cur.BillingPeriod = s.BillingPeriod
+	cur.UserID = s.UserID
+	cur.StartMonth = s.StartMonth
+	cur.EndMonth = cloneTime(s.EndMonth)
+	cur.StartDate = cloneTime(s.StartDate)
+	cur.EndDate = cloneTime(s.EndDate)
+	cur.TrialUntil = cloneTime(s.TrialUntil)
+	cur.UpdatedAt = time.Now()
+	cur.Version++
+	if err := m.addEvent(ctx, id, domain.EventUpdate, before, cur); err != nil {
+		return domain.Subscription{}, err
+	}
+	m.subs[id] = cur
+	return cloneSub(cur), nil
+}
+
+func (m *Memory) deleteLocked(ctx context.Context, id uuid.UUID, ifVersion int) (domain.Subscription, error) {
+	before, ok := m.subs[id]
+	if !ok || before.DeletedAt != nil {
+		return domain.Subscription{}, ErrNotFound
+	}
+	if ifVersion != 0 && before.Version != ifVersion {
+		return domain.Subscription{}, ErrVersionMismatch
+	}
+	after := before
+	now := time.Now()
+	after.DeletedAt = &now
+	after.Version++
+	if err := m.addEvent(ctx, id, domain.EventDelete, before, after); err != nil {
+		return domain.Subscription{}, err
+	}
+	m.subs[id] = after
+	return cloneSub(after), nil
+}
+
+func (m *Memory) Restore(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before, ok := m.subs[id]
+	if !ok || before.DeletedAt == nil {
+		return ErrNotFound
+	}
+	after := before
+	after.DeletedAt = nil
+	after.Version++
+	if err := m.addEvent(ctx, id, domain.EventRestore, before, after); err != nil {
+		return err
+	}
+	m.subs[id] = after
+	return nil
+}
+
+func (m *Memory) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, s := range m.subs {
+		if s.DeletedAt == nil || !s.DeletedAt.Before(cutoff) {
+			continue
+		}
+		if err := m.addEvent(ctx, id, domain.EventPurge, s, nil); err != nil {
+			return n, err
+		}
+		delete(m.subs, id)
+		delete(m.prices, id)
+		delete(m.pauses, id)
+		n++
+	}
+	now := time.Now()
+	for k, rec := range m.idem {
+		if !now.Before(rec.ExpiresAt) {
+			delete(m.idem, k)
+		}
+	}
+	logger.Log.Infof("purged %d subscriptions deleted before %s", n, cutoff.Format(time.RFC3339))
+	return n, nil
+}
+
+func (m *Memory) Summary(_ context.Context, f SummaryFilter) (domain.SummaryResult, error) {
+	logger.Log.Infof("summary requested: from=%v to=%v user_id=%v service_name=%v currency=%s", f.From, f.To, f.UserID, f.ServiceName, f.Currency)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	agg := newSummaryAgg(f, m)
+	for _, s := range m.subs {
+		if (s.DeletedAt != nil && !f.IncludeDeleted) || !f.includes(s) {
+			continue
+		}
+		agg.add(s)
+	}
+	res, err := agg.result()
+	if err != nil {
+		return res, err
+	}
+	logger.Log.Infof("summary total=%d %s groups=%d", res.Total, res.Currency, len(res.Rows))
+	return res, nil
+}
+
+// rateAt returns the latest rate of currency effective at month.
+// Callers must hold m.mu.
+func (m *Memory) rateAt(currency string, month time.Time) (float64, bool) {
+	rates := m.rates[currency]
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Month.After(month) })
+	if i == 0 {
+		return 0, false
+	}
+	return rates[i-1].Rate, true
+}
+
+func (m *Memory) UpsertRates(_ context.Context, rates []domain.ExchangeRate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rt := range rates {
+		list := m.rates[rt.Currency]
+		i := sort.Search(len(list), func(i int) bool { return !list[i].Month.Before(rt.Month) })
+		if i < len(list) && list[i].Month.Equal(rt.Month) {
+			list[i] = rt
+		} else {
+			list = slices.Insert(list, i, rt)
+		}
+		m.rates[rt.Currency] = list
+	}
+	return nil
+}
+
+func (m *Memory) ListRates(_ context.Context) ([]domain.ExchangeRate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []domain.ExchangeRate
+	for _, list := range m.rates {
+		out = append(out, list...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Currency != out[j].Currency {
+			return out[i].Currency < out[j].Currency
+		}
+		return out[i].Month.Before(out[j].Month)
+	})
+	return out, nil
+}
+
+// matches mirrors the conditions listConds builds for Postgres.
+func (f ListFilter) matches(s domain.Subscription) bool {
+	if s.DeletedAt != nil && !f.IncludeDeleted {
+		return false
+	}
+	if len(f.UserIDs) > 0 && !slices.Contains(f.UserIDs, s.UserID) {
+		return false
+	}
+	if f.ServiceName != nil {
+		name, want := strings.ToLower(s.ServiceName), strings.ToLower(*f.ServiceName)
+		var ok bool
+		switch f.ServiceMatch {
+		case MatchIExact:
+			ok = name == want
+		case MatchPrefix:
+			ok = strings.HasPrefix(name, want)
+		case MatchContains:
+			ok = strings.Contains(name, want)
+		default:
+			ok = s.ServiceName == *f.ServiceName
+		}
+		if !ok {
+			return false
+		}
+	}
+	if f.Category != nil && (s.Category == nil || *s.Category != *f.Category) {
+		return false
+	}
+	for _, t := range f.Tags {
+		if !slices.Contains(s.Tags, t) {
+			return false
+		}
+	}
+	if (f.PriceMin != nil && s.Price < *f.PriceMin) || (f.PriceMax != nil && s.Price > *f.PriceMax) {
+		return false
+	}
+	if m := f.ActiveIn; m != nil && (s.StartMonth.After(*m) || (s.EndMonth != nil && s.EndMonth.Before(*m))) {
+		return false
+	}
+	anchor := s.Anchor()
+	if (f.StartedFrom != nil && anchor.Before(*f.StartedFrom)) || (f.StartedTo != nil && anchor.After(*f.StartedTo)) {
+		return false
+	}
+	if f.EndedFrom != nil || f.EndedTo != nil {
+		last := s.LastDay()
+		if last == nil || (f.EndedFrom != nil && last.Before(*f.EndedFrom)) || (f.EndedTo != nil && last.After(*f.EndedTo)) {
+			return false
+		}
+	}
+	if f.TrialEndsFrom != nil || f.TrialEndsTo != nil {
+		t := s.TrialUntil
+		if t == nil || (f.TrialEndsFrom != nil && t.Before(*f.TrialEndsFrom)) || (f.TrialEndsTo != nil && t.After(*f.TrialEndsTo)) {
+			return false
+		}
+	}
+	if f.OpenEnded != nil && *f.OpenEnded != (s.EndMonth == nil) {
+		return false
+	}
+	return true
+}
+
+// compare orders a and b by the sort field alone.
+func (o ListSort) compare(a, b domain.Subscription) int {
+	switch o.Field {
+	case SortPrice:
+		return a.Price - b.Price
+	case SortStartMonth:
+		return a.StartMonth.Compare(b.StartMonth)
+	case SortServiceName:
+		return strings.Compare(a.ServiceName, b.ServiceName)
+	default:
+		return a.CreatedAt.Compare(b.CreatedAt)
+	}
+}
+
+// cloneSub copies the pointer fields of s so callers cannot mutate stored
+// state.
+func cloneSub(s domain.Subscription) domain.Subscription {
+	s.ServiceID = cloneUUID(s.ServiceID)
+	s.Category = cloneString(s.Category)
+	s.Tags = cloneTags(s.Tags)
+	s.EndMonth = cloneTime(s.EndMonth)
+	s.StartDate = cloneTime(s.StartDate)
+	s.EndDate = cloneTime(s.EndDate)
+	s.TrialUntil = cloneTime(s.TrialUntil)
+	s.DeletedAt = cloneTime(s.DeletedAt)
+	return s
+}
+
+func cloneUUID(id *uuid.UUID) *uuid.UUID {
+	if id == nil {
+		return nil
+	}
+	c := *id
+	return &c
+}
+
+func cloneString(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	return &c
+}
+
+// cloneTags copies tags, turning nil into an empty list as Repo does.
+func cloneTags(tags []string) []string {
+	return append([]string{}, tags...)
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// priceAt returns the price of s effective at month.
+// Callers must hold m.mu.
+func (m *Memory) priceAt(s domain.Subscription, month time.Time) int {
+	changes := m.prices[s.ID]
+	i := sort.Search(len(changes), func(i int) bool { return changes[i].EffectiveFrom.After(month) })
+	if i == 0 {
+		return s.Price
+	}
+	return changes[i-1].Price
+}
+
+func (m *Memory) SchedulePriceChange(ctx context.Context, pc domain.PriceChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.subs[pc.SubscriptionID]; !ok || s.DeletedAt != nil {
+		return ErrNotFound
+	}
+	pc.CreatedAt = time.Now()
+	if err := m.addEvent(ctx, pc.SubscriptionID, domain.EventPriceChange, nil, pc); err != nil {
+		return err
+	}
+	list := m.prices[pc.SubscriptionID]
+	i := sort.Search(len(list), func(i int) bool { return !list[i].EffectiveFrom.Before(pc.EffectiveFrom) })
+	if i < len(list) && list[i].EffectiveFrom.Equal(pc.EffectiveFrom) {
+		list[i] = pc
+	} else {
+		list = slices.Insert(list, i, pc)
+	}
+	m.prices[pc.SubscriptionID] = list
+	return nil
+}
+
+func (m *Memory) ListPriceChanges(_ context.Context, id uuid.UUID) ([]domain.PriceChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.prices[id]), nil
+}
+
+// pausedIn reports whether s is paused during month.
+// Callers must hold m.mu.
+func (m *Memory) pausedIn(s domain.Subscription, month time.Time) bool {
+	return slices.ContainsFunc(m.pauses[s.ID], func(p domain.Pause) bool { return p.Covers(month) })
+}
+
+func (m *Memory) Pause(ctx context.Context, p domain.Pause) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.subs[p.SubscriptionID]; !ok || s.DeletedAt != nil {
+		return ErrNotFound
+	}
+	list := m.pauses[p.SubscriptionID]
+	if slices.ContainsFunc(list, p.Overlaps) {
+		return ErrPauseOverlap
+	}
+	p.Until = cloneTime(p.Until)
+	p.CreatedAt = time.Now()
+	if err := m.addEvent(ctx, p.SubscriptionID, domain.EventPause, nil, p); err != nil {
+		return err
+	}
+	i := sort.Search(len(list), func(i int) bool { return list[i].From.After(p.From) })
+	m.pauses[p.SubscriptionID] = slices.Insert(list, i, p)
+	return nil
+}
+
+func (m *Memory) Resume(ctx context.Context, id uuid.UUID, month time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.subs[id]; !ok || s.DeletedAt != nil {
+		return ErrNotFound
+	}
+	list := m.pauses[id]
+	i := slices.IndexFunc(list, func(p domain.Pause) bool { return p.Covers(month) })
+	if i < 0 {
+		return ErrNotPaused
+	}
+	before := list[i]
+	if !before.From.Before(month) {
+		if err := m.addEvent(ctx, id, domain.EventResume, before, nil); err != nil {
+			return err
+		}
+		m.pauses[id] = slices.Delete(list, i, i+1)
+		return nil
+	}
+	after := before
+	until := month.AddDate(0, -1, 0)
+	after.Until = &until
+	if err := m.addEvent(ctx, id, domain.EventResume, before, after); err != nil {
+		return err
+	}
+	list[i] = after
+	return nil
+}
+
+func (m *Memory) ListPauses(_ context.Context, id uuid.UUID) ([]domain.Pause, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := slices.Clone(m.pauses[id])
+	for i := range out {
+		out[i].Until = cloneTime(out[i].Until)
+	}
+	return out, nil
+}
+
+// addEvent appends an audit event; a nil before or after is stored as null.
+// Callers must hold m.mu for writing.
+func (m *Memory) addEvent(ctx context.Context, id uuid.UUID, action domain.EventAction, before, after any) error {
+	b, err := snapshot(before)
+	if err != nil {
+		return err
+	}
+	a, err := snapshot(after)
+	if err != nil {
+		return err
+	}
+	m.events = append(m.events, domain.SubscriptionEvent{
+		ID:             int64(len(m.events) + 1),
+		SubscriptionID: id,
+		Actor:          actorFrom(ctx),
+		Action:         action,
+		Before:         b,
+		After:          a,
+		CreatedAt:      time.Now(),
+	})
+	return nil
+}
+
+func (m *Memory) History(_ context.Context, id uuid.UUID) ([]domain.SubscriptionEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []domain.SubscriptionEvent
+	for _, e := range m.events {
+		if e.SubscriptionID == id {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// resolveServiceLocked links s to the service its name resolves to and
+// sets the canonical name and a missing category, or unlinks it. Callers must hold m.mu.
+func (m *Memory) resolveServiceLocked(s *domain.Subscription) {
+	s.ServiceID = nil
+	id, ok := m.serviceKeys[domain.ServiceKey(s.ServiceName)]
+	if !ok {
+		return
+	}
+	sv := m.services[id]
+	s.ServiceID, s.ServiceName = &id, sv.Name
+	if s.Category == nil {
+		s.Category = cloneString(sv.Category)
+	}
+}
+
+func (m *Memory) CreateService(ctx context.Context, sv domain.Service) (domain.Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sv.ID = uuid.New()
+	if err := m.checkServiceKeysLocked(sv); err != nil {
+		return domain.Service{}, err
+	}
+	now := time.Now()
+	sv.CreatedAt, sv.UpdatedAt = now, now
+	sv = cloneService(sv)
+	m.services[sv.ID] = sv
+	for _, k := range sv.Keys() {
+		m.serviceKeys[k] = sv.ID
+	}
+	if err := m.relinkLocked(ctx, sv); err != nil {
+		return domain.Service{}, err
+	}
+	return cloneService(sv), nil
+}
+
+func (m *Memory) GetService(_ context.Context, id uuid.UUID) (domain.Service, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sv, ok := m.services[id]
+	if !ok {
+		return domain.Service{}, ErrNotFound
+	}
+	return cloneService(sv), nil
+}
+
+func (m *Memory) ListServices(_ context.Context) ([]domain.Service, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]domain.Service, 0, len(m.services))
+	for _, sv := range m.services {
+		out = append(out, cloneService(sv))
+	}
+	slices.SortFunc(out, func(a, b domain.Service) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return out, nil
+}
+
+func (m *Memory) UpdateService(ctx context.Context, id uuid.UUID, sv domain.Service) (domain.Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.services[id]
+	if !ok {
+		return domain.Service{}, ErrNotFound
+	}
+	sv.ID = id
+	if err := m.checkServiceKeysLocked(sv); err != nil {
+		return domain.Service{}, err
+	}
+	sv.CreatedAt = cur.CreatedAt
+	sv.UpdatedAt = time.Now()
+	sv = cloneService(sv)
+	for _, k := range cur.Keys() {
+		delete(m.serviceKeys, k)
+	}
+	m.services[id] = sv
+	for _, k := range sv.Keys() {
+		m.serviceKeys[k] = id
+	}
+	if err := m.relinkLocked(ctx, sv); err != nil {
+		return domain.Service{}, err
+	}
+	return cloneService(sv), nil
+}
+
+func (m *Memory) DeleteService(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sv, ok := m.services[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for sid, before := range m.subs {
+		if before.ServiceID == nil || *before.ServiceID != id {
+			continue
+		}
+		after := cloneSub(before)
+		after.ServiceID = nil
+		if before.DeletedAt == nil {
+			after.UpdatedAt = time.Now()
+			after.Version++
+			if err := m.addEvent(ctx, sid, domain.EventUpdate, before, after); err != nil {
+				return err
+			}
+		}
+		m.subs[sid] = after
+	}
+	for _, k := range sv.Keys() {
+		delete(m.serviceKeys, k)
+	}
+	delete(m.services, id)
+	for bid, b := range m.budgets {
+		if b.ServiceID != nil && *b.ServiceID == id {
+			b.ServiceID = nil
+			m.budgets[bid] = b
+		}
+	}
+	return nil
+}
+
+// checkServiceKeysLocked returns ErrConflict if a key of sv belongs to
+// another service. Callers must hold m.mu.
+func (m *Memory) checkServiceKeysLocked(sv domain.Service) error {
+	for _, k := range sv.Keys() {
+		if owner, ok := m.serviceKeys[k]; ok && owner != sv.ID {
+			return fmt.Errorf("%w: service key %q is taken", ErrConflict, k)
+		}
+	}
+	return nil
+}
+
+// needsRelink reports whether a record with the given service link and
+// name is to be linked to sv, whose lookup keys are keys, or renamed after
+// it.
+func needsRelink(sv domain.Service, keys []string, serviceID *uuid.UUID, name string) bool {
+	if serviceID != nil {
+		return *serviceID == sv.ID && name != sv.Name
+	}
+	return slices.Contains(keys, domain.ServiceKey(name))
+}
+
+// relinkLocked is the Memory counterpart of relinkTx. Callers must hold
+// m.mu for writing.
+func (m *Memory) relinkLocked(ctx context.Context, sv domain.Service) error {
+	keys := sv.Keys()
+	for id, before := range m.subs {
+		if before.DeletedAt != nil || !needsRelink(sv, keys, before.ServiceID, before.ServiceName) {
+			continue
+		}
+		after := cloneSub(before)
+		after.ServiceID = cloneUUID(&sv.ID)
+		after.ServiceName = sv.Name
+		if after.Category == nil {
+			after.Category = cloneString(sv.Category)
+		}
+		after.UpdatedAt = time.Now()
+		after.Version++
+		if err := m.addEvent(ctx, id, domain.EventUpdate, before, after); err != nil {
+			return err
+		}
+		m.subs[id] = after
+	}
+	m.relinkBudgetsLocked(sv)
+	return nil
+}
+
+// relinkBudgetsLocked is the Memory counterpart of relinkBudgetsTx.
+// Callers must hold m.mu for writing.
+func (m *Memory) relinkBudgetsLocked(sv domain.Service) {
+	keys := sv.Keys()
+	ids := slices.SortedFunc(maps.Keys(m.budgets), func(a, b uuid.UUID) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	for _, id := range ids {
+		b := m.budgets[id]
+		if b.ServiceName == nil || !needsRelink(sv, keys, b.ServiceID, *b.ServiceName) {
+			continue
+		}
+		after := cloneBudget(b)
+		after.ServiceID, after.ServiceName = cloneUUID(&sv.ID), cloneString(&sv.Name)
+		if m.checkBudgetScopeLocked(after) != nil {
+			continue
+		}
+		after.UpdatedAt = time.Now()
+		m.budgets[id] = after
+	}
+}
+
+// resolveBudgetServiceLocked is the Memory counterpart of
+// resolveBudgetService. Callers must hold m.mu.
+func (m *Memory) resolveBudgetServiceLocked(b *domain.Budget) {
+	b.ServiceID = nil
+	if b.ServiceName == nil {
+		return
+	}
+	if id, ok := m.serviceKeys[domain.ServiceKey(*b.ServiceName)]; ok {
+		name := m.services[id].Name
+		b.ServiceID, b.ServiceName = &id, &name
+	}
+}
+
+func cloneService(sv domain.Service) domain.Service {
+	sv.Aliases = slices.Clone(sv.Aliases)
+	return sv
+}
+
+func (m *Memory) CreateBudget(_ context.Context, b domain.Budget) (domain.Budget, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b.ID = uuid.New()
+	m.resolveBudgetServiceLocked(&b)
+	if err := m.checkBudgetScopeLocked(b); err != nil {
+		return domain.Budget{}, err
+	}
+	now := time.Now()
+	b.CreatedAt, b.UpdatedAt = now, now
+	b = cloneBudget(b)
+	m.budgets[b.ID] = b
+	return cloneBudget(b), nil
+}
+
+func (m *Memory) GetBudget(_ context.Context, id uuid.UUID) (domain.Budget, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.budgets[id]
+	if !ok {
+		return domain.Budget{}, ErrNotFound
+	}
+	return cloneBudget(b), nil
+}
+
+func (m *Memory) ListBudgets(_ context.Context, userID *uuid.UUID) ([]domain.Budget, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []domain.Budget
+	for _, b := range m.budgets {
+		if userID == nil || b.UserID == *userID {
+			out = append(out, cloneBudget(b))
+		}
+	}
+	// Same order as Repo: nil scopes first.
+	str := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return "\x00" + *p
+	}
+	slices.SortFunc(out, func(a, b domain.Budget) int {
+		if c := strings.Compare(a.UserID.String(), b.UserID.String()); c != 0 {
+			return c
+		}
+		if c := strings.Compare(str(a.Category), str(b.Category)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(str(a.ServiceName), str(b.ServiceName)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return out, nil
+}
+
+func (m *Memory) UpdateBudget(_ context.Context, id uuid.UUID, b domain.Budget) (domain.Budget, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.budgets[id]
+	if !ok {
+		return domain.Budget{}, ErrNotFound
+	}
+	b.ID = id
+	m.resolveBudgetServiceLocked(&b)
+	if err := m.checkBudgetScopeLocked(b); err != nil {
+		return domain.Budget{}, err
+	}
+	b.CreatedAt = cur.CreatedAt
+	b.UpdatedAt = time.Now()
+	b = cloneBudget(b)
+	m.budgets[id] = b
+	return cloneBudget(b), nil
+}
+
+func (m *Memory) DeleteBudget(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.budgets[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.budgets, id)
+	return nil
+}
+
+// checkBudgetScopeLocked returns ErrConflict if another budget of the user
+// has the same scope as b. Callers must hold m.mu.
+func (m *Memory) checkBudgetScopeLocked(b domain.Budget) error {
+	for _, o := range m.budgets {
+		if o.ID != b.ID && o.UserID == b.UserID &&
+			equalPtr(o.Category, b.Category) && equalPtr(o.ServiceName, b.ServiceName) {
+			return fmt.Errorf("%w: budget scope is taken", ErrConflict)
+		}
+	}
+	return nil
+}
+
+func equalPtr(a, b *string) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+func cloneBudget(b domain.Budget) domain.Budget {
+	b.Category = cloneString(b.Category)
+	b.ServiceName = cloneString(b.ServiceName)
+	b.ServiceID = cloneUUID(b.ServiceID)
+	return b
+}
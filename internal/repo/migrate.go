@@ -0,0 +1,246 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/pavel97go/subscriptions/internal/logger"
+)
+
+// migrationLockKey is the pg_advisory_lock key shared by every replica
+// so that only one of them runs migrations at a time.
+const migrationLockKey int64 = 0x53554253 // "SUBS"
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([^.]+)(\.down)?\.sql$`)
+
+type migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type appliedMigration struct {
+	Version  int
+	Checksum string
+}
+
+func migrationsDir() string {
+	if dir := os.Getenv("MIGRATIONS_DIR"); dir != "" {
+		return dir
+	}
+	return "./migrations"
+}
+
+// loadMigrations reads NNN_name.sql (up) and NNN_name.down.sql (down)
+// files from dir and returns them ordered by version.
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations dir %s: %w", dir, err)
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", e.Name(), err)
+		}
+		body, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", e.Name(), err)
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration version %d used by both %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] != "" {
+			mig.Down = string(body)
+			continue
+		}
+		mig.Up = string(body)
+		sum := sha256.Sum256(body)
+		mig.Checksum = hex.EncodeToString(sum[:])
+	}
+
+	out := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no up file", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the
+// migration advisory lock.
+func withMigrationLock(ctx context.Context, db *pgxpool.Pool, fn func(conn *pgx.Conn) error) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire conn: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("advisory lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			logger.Log.Errorf("advisory unlock error: %v", err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER     PRIMARY KEY,
+			name       TEXT        NOT NULL,
+			checksum   TEXT        NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return fn(conn.Conn())
+}
+
+func appliedMigrations(ctx context.Context, conn *pgx.Conn) ([]appliedMigration, error) {
+	rows, err := conn.Query(ctx, `SELECT version, checksum FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("select schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	var out []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Checksum); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations: %w", err)
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) applyMigrations(ctx context.Context) error {
+	logger.Log.Info("applying migrations...")
+	migs, err := loadMigrations(migrationsDir())
+	if err != nil {
+		return err
+	}
+	err = withMigrationLock(ctx, r.db, func(conn *pgx.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		done := make(map[int]string, len(applied))
+		for _, a := range applied {
+			done[a.Version] = a.Checksum
+		}
+		known := make(map[int]bool, len(migs))
+		for _, m := range migs {
+			known[m.Version] = true
+			if sum, ok := done[m.Version]; ok {
+				if sum != m.Checksum {
+					return fmt.Errorf("migration %03d_%s was modified after being applied (checksum mismatch)", m.Version, m.Name)
+				}
+				continue
+			}
+			if err := runMigration(ctx, conn, m.Up, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx,
+					`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1,$2,$3)`,
+					m.Version, m.Name, m.Checksum)
+				return err
+			}); err != nil {
+				return fmt.Errorf("apply migration %03d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.Log.Infof("migration %03d_%s applied", m.Version, m.Name)
+		}
+		for _, a := range applied {
+			if !known[a.Version] {
+				logger.Log.Warnf("migration %03d is applied but missing on disk", a.Version)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("migrations applied successfully")
+	return nil
+}
+
+// MigrateDown rolls back the last steps applied migrations using their
+// NNN_name.down.sql files.
+func MigrateDown(ctx context.Context, dsn string, steps int) error {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("pgxpool.New: %w", err)
+	}
+	defer pool.Close()
+
+	migs, err := loadMigrations(migrationsDir())
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[int]migration, len(migs))
+	for _, m := range migs {
+		byVersion[m.Version] = m
+	}
+	return withMigrationLock(ctx, pool, func(conn *pgx.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(applied) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			a := applied[i]
+			m, ok := byVersion[a.Version]
+			if !ok || strings.TrimSpace(m.Down) == "" {
+				return fmt.Errorf("migration %03d has no down file", a.Version)
+			}
+			if err := runMigration(ctx, conn, m.Down, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version=$1`, m.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("rollback migration %03d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.Log.Infof("migration %03d_%s rolled back", m.Version, m.Name)
+		}
+		return nil
+	})
+}
+
+func runMigration(ctx context.Context, conn *pgx.Conn, body string, record func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, body); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -2,24 +2,28 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"hash/fnv"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/pavel97go/subscriptions/internal/domain"
 	"github.com/pavel97go/subscriptions/internal/logger"
-	"github.com/pavel97go/subscriptions/internal/util"
 )
 
 type Repo struct {
 	db *pgxpool.Pool
 }
 
+var _ Store = (*Repo)(nil)
+
 func New(ctx context.Context, dsn string) (*Repo, error) {
 	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
@@ -30,27 +34,82 @@ func New(ctx context.Context, dsn string) (*Repo, error) {
 		pool.Close()
 		return nil, err
 	}
+	if err := r.fillServiceKeys(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("fill service keys: %w", err)
+	}
 	return r, nil
 }
 
+// fillServiceKeys sets the service_key of the subscriptions and budgets
+// written before the column existed. It is computed here rather than in
+// the migration so that domain.ServiceKey stays the only normalization
+// rule.
+func (r *Repo) fillServiceKeys(ctx context.Context) error {
+	for _, table := range []string{"subscriptions", "budgets"} {
+		rows, err := r.db.Query(ctx, `
+			SELECT id, service_name FROM `+table+`
+			 WHERE service_key IS NULL AND service_name IS NOT NULL`)
+		if err != nil {
+			return err
+		}
+		var ids []uuid.UUID
+		var keys []string
+		var id uuid.UUID
+		var name string
+		_, err = pgx.ForEachRow(rows, []any{&id, &name}, func() error {
+			ids = append(ids, id)
+			keys = append(keys, domain.ServiceKey(name))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if _, err := r.db.Exec(ctx, `
+			UPDATE `+table+` t SET service_key = k.key
+			  FROM unnest($1::uuid[], $2::text[]) AS k(id, key)
+			 WHERE t.id = k.id AND t.service_key IS NULL`, ids, keys); err != nil {
+			return err
+		}
+		logger.Log.Infof("filled service_key of %d %s", len(ids), table)
+	}
+	return nil
+}
+
 func (r *Repo) Close() { r.db.Close() }
 
-func (r *Repo) applyMigrations(ctx context.Context) error {
-	logger.Log.Info("applying migrations...")
-	dir := os.Getenv("MIGRATIONS_DIR")
-	if dir == "" {
-		dir = "./migrations"
+// subscriptionColumns is the column list matching scanSubscription.
+const subscriptionColumns = `id, service_name, service_id, category, tags, price, currency, billing_period, user_id, start_month, end_month, start_date, end_date, trial_until, created_at, updated_at, deleted_at, version`
+
+func scanSubscription(row pgx.Row) (domain.Subscription, error) {
+	var s domain.Subscription
+	err := row.Scan(&s.ID, &s.ServiceName, &s.ServiceID, &s.Category, &s.Tags, &s.Price, &s.Currency, &s.BillingPeriod, &s.UserID, &s.StartMonth, &s.EndMonth, &s.StartDate, &s.EndDate, &s.TrialUntil, &s.CreatedAt, &s.UpdatedAt, &s.DeletedAt, &s.Version)
+	return s, err
+}
+
+// dbError translates pgx and Postgres errors into the Store errors.
+func dbError(err error) error {
+	if err == nil {
+		return nil
 	}
-	path := filepath.Join(dir, "001_init.sql")
-	body, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("read migration %s: %w", path, err)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
 	}
-	if _, err := r.db.Exec(ctx, string(body)); err != nil {
-		return fmt.Errorf("apply migration: %w", err)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505", pgErr.Code == "23P01": // unique, exclusion violation
+			return fmt.Errorf("%w: %s", ErrConflict, pgErr.ConstraintName)
+		case strings.HasPrefix(pgErr.Code, "22"), strings.HasPrefix(pgErr.Code, "23"):
+			// Class 22 is data exceptions, class 23 the remaining
+			// integrity constraint violations (check, not null, foreign key).
+			return fmt.Errorf("%w: %s", ErrValidation, pgErr.ConstraintName)
+		}
 	}
-	logger.Log.Info("migrations applied successfully")
-	return nil
+	return err
 }
 
 func (r *Repo) Create(ctx context.Context, s domain.Subscription) (uuid.UUID, error) {
@@ -58,166 +117,695 @@ func (r *Repo) Create(ctx context.Context, s domain.Subscription) (uuid.UUID, er
 	id := uuid.New()
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	_, err := r.db.Exec(ctx, `
-		INSERT INTO subscriptions (id, service_name, price, user_id, start_month, end_month)
-		VALUES ($1,$2,$3,$4,$5,$6)`,
-		id, s.ServiceName, s.Price, s.UserID, s.StartMonth, s.EndMonth,
-	)
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := createTx(ctx, tx, id, s)
+		return err
+	})
 	if err != nil {
 		logger.Log.Errorf("create exec error: %v", err)
+		return id, dbError(err)
 	}
-	return id, err
+	return id, nil
 }
 
-func (r *Repo) Get(ctx context.Context, id uuid.UUID) (domain.Subscription, error) {
+// CreateIdempotent claims key in the same transaction as the insert, so
+// concurrent requests with one key wait for each other and create once.
+func (r *Repo) CreateIdempotent(ctx context.Context, key IdempotencyKey, s domain.Subscription) (uuid.UUID, bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	var s domain.Subscription
-	var end *time.Time
-	err := r.db.QueryRow(ctx, `
-		SELECT id, service_name, price, user_id, start_month, end_month, created_at, updated_at
-		  FROM subscriptions WHERE id=$1`, id).
-		Scan(&s.ID, &s.ServiceName, &s.Price, &s.UserID, &s.StartMonth, &end, &s.CreatedAt, &s.UpdatedAt)
+	var id uuid.UUID
+	replayed := false
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		// An expired key is taken over as if it did not exist.
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO idempotency_keys (key, request_hash, expires_at) VALUES ($1,$2,$3)
+			ON CONFLICT (key) DO UPDATE
+			   SET request_hash=EXCLUDED.request_hash, subscription_id=NULL,
+			       created_at=now(), expires_at=EXCLUDED.expires_at
+			 WHERE idempotency_keys.expires_at <= now()`,
+			key.Key, key.RequestHash, key.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			var hash string
+			var prev *uuid.UUID
+			if err := tx.QueryRow(ctx, `
+				SELECT request_hash, subscription_id FROM idempotency_keys WHERE key=$1`, key.Key,
+			).Scan(&hash, &prev); err != nil {
+				return err
+			}
+			if hash != key.RequestHash || prev == nil {
+				return ErrIdempotencyKeyReused
+			}
+			id, replayed = *prev, true
+			return nil
+		}
+		id = uuid.New()
+		if _, err := createTx(ctx, tx, id, s); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE idempotency_keys SET subscription_id=$2 WHERE key=$1`, key.Key, id)
+		return err
+	})
 	if err != nil {
+		if !errors.Is(err, ErrIdempotencyKeyReused) {
+			logger.Log.Errorf("create idempotent exec error: %v", err)
+		}
+		return uuid.Nil, false, dbError(err)
+	}
+	if replayed {
+		logger.Log.Infof("idempotency key replayed: subscription %s", id)
+	}
+	return id, replayed, nil
+}
+
+func (r *Repo) Get(ctx context.Context, id uuid.UUID) (domain.Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	s, err := scanSubscription(r.db.QueryRow(ctx, `
+		SELECT `+subscriptionColumns+`
+		  FROM subscriptions WHERE id=$1 AND deleted_at IS NULL`, id))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		logger.Log.Errorf("get query error: %v", err)
-		return s, err
 	}
-	s.EndMonth = end
-	return s, nil
+	return s, dbError(err)
 }
 
 func (r *Repo) List(ctx context.Context, limit, offset int) ([]domain.Subscription, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	out, _, err := r.ListFiltered(ctx, ListFilter{}, limit, offset)
+	return out, err
+}
+
+// Update replaces a subscription. A non-zero ifVersion makes the update
+// conditional on the stored version, failing with ErrVersionMismatch.
+func (r *Repo) Update(ctx context.Context, id uuid.UUID, s domain.Subscription, ifVersion int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	rows, err := r.db.Query(ctx, `
-		SELECT id, service_name, price, user_id, start_month, end_month, created_at, updated_at
-		  FROM subscriptions
-		 ORDER BY created_at DESC
-		 LIMIT $1 OFFSET $2`, limit, offset)
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := updateTx(ctx, tx, id, s, ifVersion)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrVersionMismatch) && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("update exec error: %v", err)
+	}
+	return dbError(err)
+}
+
+// Delete soft-deletes a subscription by setting deleted_at; Purge removes
+// it for good once the retention has passed. ifVersion works as in Update.
+func (r *Repo) Delete(ctx context.Context, id uuid.UUID, ifVersion int) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := deleteTx(ctx, tx, id, ifVersion)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrVersionMismatch) && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("delete exec error: %v", err)
+	}
+	return dbError(err)
+}
+
+// Batch runs ops in order in a single transaction. Atomic batches insert
+// consecutive creates with COPY and stop at the first failing operation;
+// best-effort batches wrap every operation in a savepoint so a failure
+// only rolls back that operation.
+func (r *Repo) Batch(ctx context.Context, ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	res := make([]BatchResult, len(ops))
+	run := r.batchBestEffort
+	if atomic {
+		run = r.batchAtomic
+	}
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error { return run(ctx, tx, ops, res) })
+	if errors.Is(err, ErrBatchAborted) {
+		return res, nil
+	}
+	if err != nil {
+		logger.Log.Errorf("batch exec error: %v", err)
+		return nil, dbError(err)
+	}
+	logger.Log.Infof("batch of %d operations done (atomic=%t)", len(ops), atomic)
+	return res, nil
+}
+
+func (r *Repo) batchBestEffort(ctx context.Context, tx pgx.Tx, ops []BatchOp, res []BatchResult) error {
+	for i, op := range ops {
+		err := pgx.BeginFunc(ctx, tx, func(sp pgx.Tx) error {
+			var err error
+			res[i].Sub, err = execBatchOp(ctx, sp, op)
+			return err
+		})
+		if err != nil {
+			res[i] = BatchResult{Err: dbError(err)}
+		}
+	}
+	return nil
+}
+
+// batchAtomic runs ops in order, filling res, and returns ErrBatchAborted
+// after the first failure so that the caller rolls the transaction back.
+// Every run of consecutive creates is inserted with a single COPY; if that
+// fails, the run is retried row by row to find the failing create.
+func (r *Repo) batchAtomic(ctx context.Context, tx pgx.Tx, ops []BatchOp, res []BatchResult) error {
+	abort := func(failed int, err error) error {
+		for i := range res {
+			res[i] = BatchResult{Err: ErrBatchAborted}
+		}
+		res[failed].Err = dbError(err)
+		return ErrBatchAborted
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].Action != BatchCreate {
+			sub, err := execBatchOp(ctx, tx, ops[i])
+			if err != nil {
+				return abort(i, err)
+			}
+			res[i].Sub = sub
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(ops) && ops[j].Action == BatchCreate {
+			j++
+		}
+		err := pgx.BeginFunc(ctx, tx, func(sp pgx.Tx) error {
+			return copyCreates(ctx, sp, ops[i:j], res[i:j])
+		})
+		if err != nil {
+			for k := i; k < j; k++ {
+				err := pgx.BeginFunc(ctx, tx, func(sp pgx.Tx) error {
+					_, err := createTx(ctx, sp, uuid.New(), ops[k].Sub)
+					return err
+				})
+				if err != nil {
+					return abort(k, err)
+				}
+			}
+			return abort(i, err)
+		}
+		i = j
+	}
+	return nil
+}
+
+// copyCreates inserts the subscriptions of the create operations ops and
+// their audit events with COPY, storing the created rows in res.
+func copyCreates(ctx context.Context, tx pgx.Tx, ops []BatchOp, res []BatchResult) error {
+	now := time.Now()
+	actor := actorFrom(ctx)
+	created := make([]*domain.Subscription, 0, len(ops))
+	for _, op := range ops {
+		s := op.Sub
+		created = append(created, &s)
+	}
+	if err := resolveServices(ctx, tx, created); err != nil {
+		return err
+	}
+	subs := make([][]any, 0, len(ops))
+	events := make([][]any, 0, len(ops))
+	for i := range ops {
+		s := *created[i]
+		s.ID = uuid.New()
+		s.CreatedAt, s.UpdatedAt, s.Version = now, now, 1
+		after, err := snapshot(s)
+		if err != nil {
+			return err
+		}
+		if s.Tags == nil {
+			s.Tags = []string{}
+		}
+		subs = append(subs, []any{s.ID, s.ServiceName, s.ServiceID, s.Category, s.Tags, s.Price, s.Currency, s.BillingPeriod, s.UserID,
+			s.StartMonth, s.EndMonth, s.StartDate, s.EndDate, s.TrialUntil, s.CreatedAt, s.UpdatedAt, s.Version, domain.ServiceKey(s.ServiceName)})
+		events = append(events, []any{s.ID, actor, domain.EventCreate, nil, after})
+		res[i].Sub = s
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"subscriptions"},
+		[]string{"id", "service_name", "service_id", "category", "tags", "price", "currency", "billing_period", "user_id",
+			"start_month", "end_month", "start_date", "end_date", "trial_until", "created_at", "updated_at", "version", "service_key"},
+		pgx.CopyFromRows(subs)); err != nil {
+		return err
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"subscription_events"},
+		[]string{"subscription_id", "actor", "action", "before", "after"},
+		pgx.CopyFromRows(events))
+	return err
+}
+
+func execBatchOp(ctx context.Context, tx pgx.Tx, op BatchOp) (domain.Subscription, error) {
+	switch op.Action {
+	case BatchCreate:
+		return createTx(ctx, tx, uuid.New(), op.Sub)
+	case BatchUpdate:
+		return updateTx(ctx, tx, op.ID, op.Sub, op.IfVersion)
+	case BatchDelete:
+		return deleteTx(ctx, tx, op.ID, op.IfVersion)
+	}
+	return domain.Subscription{}, fmt.Errorf("%w: unknown batch action %q", ErrValidation, op.Action)
+}
+
+func createTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, s domain.Subscription) (domain.Subscription, error) {
+	if err := resolveServices(ctx, tx, []*domain.Subscription{&s}); err != nil {
+		return s, err
+	}
+	created, err := scanSubscription(tx.QueryRow(ctx, `
+		INSERT INTO subscriptions (id, service_name, service_id, category, tags, price, currency, billing_period, user_id, start_month, end_month, start_date, end_date, trial_until, service_key)
+		VALUES ($1,$2,$3,$4,COALESCE($5::text[], '{}'),$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+		RETURNING `+subscriptionColumns,
+		id, s.ServiceName, s.ServiceID, s.Category, s.Tags, s.Price, s.Currency, s.BillingPeriod, s.UserID, s.StartMonth, s.EndMonth, s.StartDate, s.EndDate, s.TrialUntil,
+		domain.ServiceKey(s.ServiceName),
+	))
+	if err != nil {
+		return created, err
+	}
+	return created, insertEvent(ctx, tx, id, domain.EventCreate, nil, created)
+}
+
+func updateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, s domain.Subscription, ifVersion int) (domain.Subscription, error) {
+	before, err := lockSubscription(ctx, tx, id, ifVersion)
+	if err != nil {
+		return before, err
+	}
+	if err := checkFixedTerms(before, s); err != nil {
+		return before, err
+	}
+	if err := resolveServices(ctx, tx, []*domain.Subscription{&s}); err != nil {
+		return before, err
+	}
+	after, err := scanSubscription(tx.QueryRow(ctx, `
+		UPDATE subscriptions
+		   SET service_name=$2, service_id=$3, category=$4, tags=COALESCE($5::text[], '{}'),
+		       currency=$6, billing_period=$7, user_id=$8,
+		       start_month=$9, end_month=$10, start_date=$11, end_date=$12, trial_until=$13,
+		       service_key=$14, updated_at=now(), version=version+1
+		 WHERE id=$1
+		RETURNING `+subscriptionColumns,
+		id, s.ServiceName, s.ServiceID, s.Category, s.Tags, s.Currency, s.BillingPeriod, s.UserID, s.StartMonth, s.EndMonth, s.StartDate, s.EndDate, s.TrialUntil,
+		domain.ServiceKey(s.ServiceName),
+	))
+	if err != nil {
+		return after, err
+	}
+	return after, insertEvent(ctx, tx, id, domain.EventUpdate, before, after)
+}
+
+func deleteTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, ifVersion int) (domain.Subscription, error) {
+	before, err := lockSubscription(ctx, tx, id, ifVersion)
+	if err != nil {
+		return before, err
+	}
+	after, err := scanSubscription(tx.QueryRow(ctx, `
+		UPDATE subscriptions SET deleted_at=now(), version=version+1 WHERE id=$1
+		RETURNING `+subscriptionColumns, id))
+	if err != nil {
+		return after, err
+	}
+	return after, insertEvent(ctx, tx, id, domain.EventDelete, before, after)
+}
+
+// resolveServices links each subscription whose service_name is a known
+// service name or alias to that service, replaces the name with the
+// canonical one and fills in a missing category; the others are unlinked.
+func resolveServices(ctx context.Context, tx pgx.Tx, subs []*domain.Subscription) error {
+	keys := make([]string, 0, len(subs))
+	for _, s := range subs {
+		keys = append(keys, domain.ServiceKey(s.ServiceName))
+	}
+	if err := lockServiceKeys(ctx, tx, keys, true); err != nil {
+		return err
+	}
+	found, err := lookupServiceKeys(ctx, tx, keys)
+	if err != nil {
+		return err
+	}
+	for i, s := range subs {
+		s.ServiceID = nil
+		if m, ok := found[keys[i]]; ok {
+			id := m.id
+			s.ServiceID, s.ServiceName = &id, m.name
+			if s.Category == nil {
+				s.Category = m.category
+			}
+		}
+	}
+	return nil
+}
+
+type serviceMatch struct {
+	id       uuid.UUID
+	name     string
+	category *string
+}
+
+// lookupServiceKeys returns the services the given keys belong to.
+func lookupServiceKeys(ctx context.Context, tx pgx.Tx, keys []string) (map[string]serviceMatch, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT k.key, s.id, s.name, s.category
+		  FROM service_keys k JOIN services s ON s.id = k.service_id
+		 WHERE k.key = ANY($1)`, keys)
 	if err != nil {
-		logger.Log.Errorf("list query error: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
-
-	var out []domain.Subscription
+	found := map[string]serviceMatch{}
 	for rows.Next() {
-		var s domain.Subscription
-		var end *time.Time
-		if err := rows.Scan(&s.ID, &s.ServiceName, &s.Price, &s.UserID, &s.StartMonth, &end, &s.CreatedAt, &s.UpdatedAt); err != nil {
-			logger.Log.Errorf("list scan error: %v", err)
+		var k string
+		var m serviceMatch
+		if err := rows.Scan(&k, &m.id, &m.name, &m.category); err != nil {
 			return nil, err
 		}
-		s.EndMonth = end
-		out = append(out, s)
+		found[k] = m
 	}
-	if err := rows.Err(); err != nil {
-		logger.Log.Errorf("list rows error: %v", err)
-		return nil, err
+	return found, rows.Err()
+}
+
+// resolveBudgetService links b to the service its ServiceName resolves to
+// and sets the canonical name, or unlinks it.
+func resolveBudgetService(ctx context.Context, tx pgx.Tx, b *domain.Budget) error {
+	b.ServiceID = nil
+	if b.ServiceName == nil {
+		return nil
 	}
-	return out, nil
+	key := domain.ServiceKey(*b.ServiceName)
+	if err := lockServiceKeys(ctx, tx, []string{key}, true); err != nil {
+		return err
+	}
+	found, err := lookupServiceKeys(ctx, tx, []string{key})
+	if err != nil {
+		return err
+	}
+	if m, ok := found[key]; ok {
+		id, name := m.id, m.name
+		b.ServiceID, b.ServiceName = &id, &name
+	}
+	return nil
+}
+
+// lockSubscription selects a live subscription FOR UPDATE and checks it
+// against ifVersion (0 skips the check).
+func lockSubscription(ctx context.Context, tx pgx.Tx, id uuid.UUID, ifVersion int) (domain.Subscription, error) {
+	s, err := scanSubscription(tx.QueryRow(ctx, `
+		SELECT `+subscriptionColumns+` FROM subscriptions WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id))
+	if err != nil {
+		return s, err
+	}
+	if ifVersion != 0 && s.Version != ifVersion {
+		return s, ErrVersionMismatch
+	}
+	return s, nil
 }
 
-func (r *Repo) Update(ctx context.Context, id uuid.UUID, s domain.Subscription) error {
+// Restore clears deleted_at of a soft-deleted subscription. It returns
+// ErrNotFound if there is no such deleted subscription.
+func (r *Repo) Restore(ctx context.Context, id uuid.UUID) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	_, err := r.db.Exec(ctx, `
-		UPDATE subscriptions
-		   SET service_name=$2, price=$3, user_id=$4, start_month=$5, end_month=$6, updated_at=now()
-		 WHERE id=$1`,
-		id, s.ServiceName, s.Price, s.UserID, s.StartMonth, s.EndMonth,
-	)
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		before, err := scanSubscription(tx.QueryRow(ctx, `
+			SELECT `+subscriptionColumns+` FROM subscriptions WHERE id=$1 AND deleted_at IS NOT NULL FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		after, err := scanSubscription(tx.QueryRow(ctx, `
+			UPDATE subscriptions SET deleted_at=NULL, version=version+1 WHERE id=$1
+			RETURNING `+subscriptionColumns, id))
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, id, domain.EventRestore, before, after)
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("restore exec error: %v", err)
+	}
+	return dbError(err)
+}
+
+// Purge permanently removes subscriptions soft-deleted before cutoff and
+// returns how many were removed.
+func (r *Repo) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	n := 0
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			DELETE FROM subscriptions WHERE deleted_at < $1
+			RETURNING `+subscriptionColumns, cutoff)
+		if err != nil {
+			return err
+		}
+		purged, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Subscription, error) {
+			return scanSubscription(row)
+		})
+		if err != nil {
+			return err
+		}
+		for _, s := range purged {
+			if err := insertEvent(ctx, tx, s.ID, domain.EventPurge, s, nil); err != nil {
+				return err
+			}
+		}
+		n = len(purged)
+		_, err = tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at < now()`)
+		return err
+	})
 	if err != nil {
-		logger.Log.Errorf("update exec error: %v", err)
+		logger.Log.Errorf("purge exec error: %v", err)
+		return 0, err
 	}
+	logger.Log.Infof("purged %d subscriptions deleted before %s", n, cutoff.Format(time.RFC3339))
+	return n, nil
+}
+
+// insertEvent records an audit event inside the mutation's transaction.
+func insertEvent(ctx context.Context, tx pgx.Tx, id uuid.UUID, action domain.EventAction, before, after any) error {
+	b, err := snapshot(before)
+	if err != nil {
+		return err
+	}
+	a, err := snapshot(after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO subscription_events (subscription_id, actor, action, before, after)
+		VALUES ($1,$2,$3,$4,$5)`,
+		id, actorFrom(ctx), action, b, a,
+	)
 	return err
 }
 
-func (r *Repo) Delete(ctx context.Context, id uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+func (r *Repo) History(ctx context.Context, id uuid.UUID) ([]domain.SubscriptionEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	_, err := r.db.Exec(ctx, `DELETE FROM subscriptions WHERE id=$1`, id)
+	rows, err := r.db.Query(ctx, `
+		SELECT id, subscription_id, actor, action, before, after, created_at
+		  FROM subscription_events
+		 WHERE subscription_id=$1
+		 ORDER BY id`, id)
 	if err != nil {
-		logger.Log.Errorf("delete exec error: %v", err)
+		logger.Log.Errorf("history query error: %v", err)
+		return nil, err
 	}
-	return err
+	defer rows.Close()
+	var out []domain.SubscriptionEvent
+	for rows.Next() {
+		var e domain.SubscriptionEvent
+		if err := rows.Scan(&e.ID, &e.SubscriptionID, &e.Actor, &e.Action, &e.Before, &e.After, &e.CreatedAt); err != nil {
+			logger.Log.Errorf("history scan error: %v", err)
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// listOrderColumns maps sort fields to columns; only these ever reach SQL.
+var listOrderColumns = map[ListSortField]string{
+	SortCreatedAt:  "created_at",
+	SortPrice:      "price",
+	SortStartMonth: "start_month",
+	// Byte order, as Memory compares names.
+	SortServiceName: `service_name COLLATE "C"`,
 }
 
-type SummaryFilter struct {
-	UserID      *uuid.UUID
-	ServiceName *string
-	From, To    time.Time
+// anchorExpr and lastDayExpr are the first and last active day of a row.
+const (
+	anchorExpr  = `COALESCE(start_date, start_month)`
+	lastDayExpr = `COALESCE(end_date, (end_month + interval '1 month - 1 day')::date)`
+)
+
+// listConds builds the WHERE conditions of f (without the cursor) with
+// their positional arguments.
+func listConds(f ListFilter) ([]string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "$?", fmt.Sprintf("$%d", len(args))))
+	}
+	if !f.IncludeDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if len(f.UserIDs) > 0 {
+		add("user_id = ANY($?)", f.UserIDs)
+	}
+	if f.ServiceName != nil {
+		switch f.ServiceMatch {
+		case MatchIExact:
+			add(`service_name ILIKE $? ESCAPE '\'`, escapeLike(*f.ServiceName))
+		case MatchPrefix:
+			add(`service_name ILIKE $? ESCAPE '\'`, escapeLike(*f.ServiceName)+"%")
+		case MatchContains:
+			add(`service_name ILIKE $? ESCAPE '\'`, "%"+escapeLike(*f.ServiceName)+"%")
+		default:
+			add("service_name = $?", *f.ServiceName)
+		}
+	}
+	if f.Category != nil {
+		add("category = $?", *f.Category)
+	}
+	if len(f.Tags) > 0 {
+		add("tags @> $?::text[]", f.Tags)
+	}
+	if f.PriceMin != nil {
+		add("price >= $?", *f.PriceMin)
+	}
+	if f.PriceMax != nil {
+		add("price <= $?", *f.PriceMax)
+	}
+	if f.ActiveIn != nil {
+		add("start_month <= $?::date AND (end_month IS NULL OR end_month >= $?::date)", *f.ActiveIn)
+	}
+	if f.StartedFrom != nil {
+		add(anchorExpr+" >= $?::date", *f.StartedFrom)
+	}
+	if f.StartedTo != nil {
+		add(anchorExpr+" <= $?::date", *f.StartedTo)
+	}
+	if f.EndedFrom != nil {
+		add(lastDayExpr+" >= $?::date", *f.EndedFrom)
+	}
+	if f.EndedTo != nil {
+		add(lastDayExpr+" <= $?::date", *f.EndedTo)
+	}
+	if f.TrialEndsFrom != nil {
+		add("trial_until >= $?::date", *f.TrialEndsFrom)
+	}
+	if f.TrialEndsTo != nil {
+		add("trial_until <= $?::date", *f.TrialEndsTo)
+	}
+	if f.OpenEnded != nil {
+		if *f.OpenEnded {
+			conds = append(conds, "end_month IS NULL")
+		} else {
+			conds = append(conds, "end_month IS NOT NULL")
+		}
+	}
+	return conds, args
 }
 
-type ListFilter struct {
-	UserID      *uuid.UUID
-	ServiceName *string
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
 
-func (r *Repo) ListFiltered(ctx context.Context, f ListFilter, limit, offset int) ([]domain.Subscription, error) {
+func (r *Repo) ListFiltered(ctx context.Context, f ListFilter, limit, offset int) ([]domain.Subscription, int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	var args []any
-	var conds []string
-	i := 1
-	if f.UserID != nil {
-		conds = append(conds, fmt.Sprintf("user_id = $%d", i))
-		args = append(args, *f.UserID)
-		i++
+	conds, args := listConds(f)
+	total := 0
+	if f.CountTotal {
+		q := "SELECT COUNT(*) FROM subscriptions"
+		if len(conds) > 0 {
+			q += " WHERE " + strings.Join(conds, " AND ")
+		}
+		if err := r.db.QueryRow(ctx, q, args...).Scan(&total); err != nil {
+			logger.Log.Errorf("list filtered count error: %v", err)
+			return nil, 0, err
+		}
 	}
-	if f.ServiceName != nil {
-		conds = append(conds, fmt.Sprintf("service_name = $%d", i))
-		args = append(args, *f.ServiceName)
-		i++
+	dir := "DESC"
+	if f.Sort.Asc {
+		dir = "ASC"
+	}
+	col, ok := listOrderColumns[f.Sort.Field]
+	if !ok {
+		col = "created_at"
+	}
+	order := fmt.Sprintf("%[1]s %[2]s, id %[2]s", col, dir)
+	i := len(args) + 1
+
+	if c := f.Cursor; c != nil {
+		cmp := "<"
+		if c.Backward {
+			cmp, order = ">", "created_at ASC, id ASC"
+		}
+		conds = append(conds, fmt.Sprintf("(created_at, id) %s ($%d, $%d)", cmp, i, i+1))
+		args = append(args, c.CreatedAt, c.ID)
+		i += 2
+		offset = 0
 	}
 	where := ""
 	if len(conds) > 0 {
 		where = "WHERE " + strings.Join(conds, " AND ")
 	}
 	q := fmt.Sprintf(`
-		SELECT id, service_name, price, user_id, start_month, end_month, created_at, updated_at
+		SELECT `+subscriptionColumns+`
 		  FROM subscriptions
 		  %s
-		 ORDER BY created_at DESC
-		 LIMIT $%d OFFSET $%d`, where, i, i+1)
+		 ORDER BY %s
+		 LIMIT $%d OFFSET $%d`, where, order, i, i+1)
 	args = append(args, limit, offset)
 
 	rows, err := r.db.Query(ctx, q, args...)
 	if err != nil {
 		logger.Log.Errorf("list filtered query error: %v", err)
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	var out []domain.Subscription
 	for rows.Next() {
-		var s domain.Subscription
-		var end *time.Time
-		if err := rows.Scan(&s.ID, &s.ServiceName, &s.Price, &s.UserID, &s.StartMonth, &end, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		s, err := scanSubscription(rows)
+		if err != nil {
 			logger.Log.Errorf("list filtered scan error: %v", err)
-			return nil, err
+			return nil, 0, err
 		}
-		s.EndMonth = end
 		out = append(out, s)
 	}
 	if err := rows.Err(); err != nil {
 		logger.Log.Errorf("list filtered rows error: %v", err)
-		return nil, err
+		return nil, 0, err
+	}
+	if f.Cursor != nil && f.Cursor.Backward {
+		slices.Reverse(out)
 	}
-	return out, nil
+	return out, total, nil
 }
 
-func (r *Repo) Summary(ctx context.Context, f SummaryFilter) (int, error) {
-	logger.Log.Infof("summary requested: from=%v to=%v user_id=%v service_name=%v", f.From, f.To, f.UserID, f.ServiceName)
+func (r *Repo) Summary(ctx context.Context, f SummaryFilter) (domain.SummaryResult, error) {
+	logger.Log.Infof("summary requested: from=%v to=%v user_id=%v service_name=%v currency=%s", f.From, f.To, f.UserID, f.ServiceName, f.Currency)
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	codes := make([]string, 0, len(domain.CurrencyExponents))
+	exps := make([]int32, 0, len(domain.CurrencyExponents))
+	for code, exp := range domain.CurrencyExponents {
+		codes = append(codes, code)
+		exps = append(exps, int32(exp))
+	}
+
 	var args []any
 	var conds []string
-	conds = append(conds, `NOT (end_month IS NOT NULL AND end_month < $1) AND start_month <= $2`)
-	args = append(args, f.From, f.To)
+	conds = append(conds, `NOT (end_month IS NOT NULL AND end_month < $1::date) AND start_month <= $2::date`)
+	if !f.IncludeDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	args = append(args, f.From, f.To, codes, exps, f.Currency, f.BaseCurrency)
 
-	i := 3
+	i := 7
 	if f.UserID != nil {
 		conds = append(conds, fmt.Sprintf("user_id = $%d", i))
 		args = append(args, *f.UserID)
@@ -228,36 +816,784 @@ func (r *Repo) Summary(ctx context.Context, f SummaryFilter) (int, error) {
 		args = append(args, *f.ServiceName)
 		i++
 	}
+	if f.Category != nil {
+		conds = append(conds, fmt.Sprintf("category = $%d", i))
+		args = append(args, *f.Category)
+		i++
+	}
+	if f.Tag != nil {
+		conds = append(conds, fmt.Sprintf("$%d = ANY(tags)", i))
+		args = append(args, *f.Tag)
+		i++
+	}
+
+	var cols []string
+	for _, g := range summaryGroupOrder {
+		if f.grouped(g) {
+			cols = append(cols, string(g))
+		}
+	}
+	// share is what a group contributes to the overall total. It is the
+	// group total except with tag grouping, where a charge is repeated for
+	// every tag and only its first tag's row counts.
+	share := `COALESCE(ROUND(SUM(amount)), 0)::bigint`
+	from := "charges"
+	if f.grouped(domain.GroupByTag) {
+		share = `COALESCE(ROUND(SUM(amount) FILTER (WHERE t.n IS NULL OR t.n = 1)), 0)::bigint`
+		from = "charges LEFT JOIN LATERAL unnest(charges.tags) WITH ORDINALITY AS t(tag, n) ON true"
+	}
+	sel := `COALESCE(ROUND(SUM(amount)), 0)::bigint, COUNT(*) FILTER (WHERE amount IS NULL), ` + share
+	var groupBy, orderBy string
+	if len(cols) > 0 {
+		sel = strings.Join(cols, ", ") + ", " + sel
+		groupBy = "GROUP BY " + strings.Join(cols, ", ")
+		order := make([]string, len(cols))
+		for j, c := range cols {
+			switch domain.SummaryGroup(c) {
+			case domain.GroupByService:
+				c += ` COLLATE "C"`
+			case domain.GroupByCategory, domain.GroupByTag:
+				c += ` COLLATE "C" NULLS FIRST`
+			}
+			order[j] = c
+		}
+		orderBy = "ORDER BY " + strings.Join(order, ", ")
+	}
 
-	q := `SELECT service_name, price, user_id, start_month, end_month
-	        FROM subscriptions
-	       WHERE ` + strings.Join(conds, " AND ")
+	// Each subscription is clamped to [from, to] and expanded into one row
+	// per month carrying the number of charges (or the monthly-equivalent
+	// share) falling into it, priced with the price in effect that month and
+	// converted to the target currency with the latest rate known for that
+	// month. A missing rate yields a NULL amount.
+	q := fmt.Sprintf(`
+		WITH cur(code, exp) AS (
+			SELECT * FROM unnest($3::text[], $4::int[])
+		),
+		periods AS (
+			SELECT id, service_name, user_id, category, tags, price, currency, billing_period,
+			       COALESCE(start_date, start_month) AS anchor,
+			       COALESCE(end_date, (end_month + interval '1 month' - interval '1 day')::date) AS last_day,
+			       GREATEST(COALESCE(start_date, start_month), trial_until + 1) AS paid_from,
+			       GREATEST(start_month, $1::date) AS p_from,
+			       LEAST(COALESCE(end_month, $2::date), $2::date) AS p_to
+			  FROM subscriptions
+			 WHERE %s
+		),
+		charges AS (
+			SELECT p.service_name, p.user_id, p.category, p.tags, g.month::date AS month,
+			       n.qty * CASE WHEN p.currency = $5 THEN pr.price::numeric
+			                    ELSE pr.price / power(10::numeric, cf.exp) * rf.rate / rt.rate * power(10::numeric, ct.exp)
+			               END AS amount
+			  FROM periods p
+			 CROSS JOIN LATERAL generate_series(p.p_from::timestamp, p.p_to::timestamp, interval '1 month') AS g(month)
+			 CROSS JOIN LATERAL (
+			       SELECT g.month::date, (g.month + interval '1 month' - interval '1 day')::date
+			 ) AS mm(m_first, m_last)
+			 CROSS JOIN LATERAL (SELECT %s) AS n(qty)
+			 CROSS JOIN LATERAL (
+			       SELECT COALESCE((
+			              SELECT sp.price FROM subscription_prices sp
+			               WHERE sp.subscription_id = p.id AND sp.effective_from <= g.month
+			               ORDER BY sp.effective_from DESC LIMIT 1), p.price)
+			 ) AS pr(price)
+			  JOIN cur cf ON cf.code = p.currency
+			  JOIN cur ct ON ct.code = $5
+			 CROSS JOIN LATERAL (%s) AS rf(rate)
+			 CROSS JOIN LATERAL (%s) AS rt(rate)
+			 WHERE n.qty > 0
+			   AND NOT EXISTS (
+			       SELECT 1 FROM subscription_pauses ps
+			        WHERE ps.subscription_id = p.id AND ps.paused_from <= g.month
+			          AND (ps.paused_until IS NULL OR ps.paused_until >= g.month))
+		)
+		SELECT %s
+		  FROM %s
+		 %s
+		 %s`,
+		strings.Join(conds, " AND "), chargeQty(f.Basis), rateLookup("p.currency"), rateLookup("$5"), sel, from, groupBy, orderBy)
 
 	rows, err := r.db.Query(ctx, q, args...)
 	if err != nil {
 		logger.Log.Errorf("summary query error: %v", err)
-		return 0, err
+		return domain.SummaryResult{}, err
 	}
 	defer rows.Close()
 
-	total := 0
+	res := domain.SummaryResult{Currency: f.Currency}
+	missing := 0
 	for rows.Next() {
-		var name string
-		var price int
-		var uid uuid.UUID
-		var start time.Time
-		var end *time.Time
-		if err := rows.Scan(&name, &price, &uid, &start, &end); err != nil {
+		var (
+			k                     summaryKey
+			category, tag         *string
+			total, miss, subtotal int
+		)
+		dest := make([]any, 0, len(cols)+3)
+		for _, c := range cols {
+			switch domain.SummaryGroup(c) {
+			case domain.GroupByMonth:
+				dest = append(dest, &k.month)
+			case domain.GroupByService:
+				dest = append(dest, &k.service)
+			case domain.GroupByUser:
+				dest = append(dest, &k.user)
+			case domain.GroupByCategory:
+				dest = append(dest, &category)
+			case domain.GroupByTag:
+				dest = append(dest, &tag)
+			}
+		}
+		dest = append(dest, &total, &miss, &subtotal)
+		if err := rows.Scan(dest...); err != nil {
 			logger.Log.Errorf("summary scan error: %v", err)
-			return 0, err
+			return domain.SummaryResult{}, err
+		}
+		if category != nil {
+			k.category = *category
+		}
+		if tag != nil {
+			k.tag = *tag
+		}
+		missing += miss
+		res.Total += subtotal
+		if len(cols) > 0 {
+			res.Rows = append(res.Rows, f.row(k, total))
 		}
-		months := util.MonthsOverlap(start, end, f.From, f.To)
-		total += price * months
 	}
 	if err := rows.Err(); err != nil {
 		logger.Log.Errorf("summary rows error: %v", err)
-		return 0, err
+		return domain.SummaryResult{}, err
+	}
+	if missing > 0 {
+		logger.Log.Warnf("summary: %d monthly charges have no exchange rate to %s", missing, f.Currency)
+		return domain.SummaryResult{}, ErrMissingRate
+	}
+	logger.Log.Infof("summary total=%d %s groups=%d", res.Total, res.Currency, len(res.Rows))
+	return res, nil
+}
+
+// chargeQty returns the SQL counterpart of summaryAgg.qty for month g.month
+// (first day mm.m_first, last day mm.m_last). Nothing is counted before
+// p.paid_from, the first day after the trial.
+func chargeQty(basis domain.SummaryBasis) string {
+	factor := `CASE p.billing_period
+			WHEN 'weekly'    THEN 52::numeric / 12
+			WHEN 'quarterly' THEN 1::numeric / 3
+			WHEN 'yearly'    THEN 1::numeric / 12
+			ELSE 1::numeric END`
+	switch basis {
+	case domain.BasisMonthly:
+		return factor + ` * CASE WHEN p.paid_from > mm.m_last THEN 0 ELSE 1 END`
+	case domain.BasisProrated:
+		return factor + ` * GREATEST(LEAST(mm.m_last, p.last_day) - GREATEST(mm.m_first, p.paid_from) + 1, 0)
+			/ EXTRACT(DAY FROM mm.m_last)`
+	}
+	monthsSinceAnchor := `((EXTRACT(YEAR FROM mm.m_first) - EXTRACT(YEAR FROM p.anchor)) * 12
+		+ EXTRACT(MONTH FROM mm.m_first) - EXTRACT(MONTH FROM p.anchor))::int`
+	chargeDay := `mm.m_first + LEAST(EXTRACT(DAY FROM p.anchor), EXTRACT(DAY FROM mm.m_last))::int - 1`
+	lastDay := `LEAST(mm.m_last, p.last_day)`
+	skipped := `GREATEST(GREATEST(mm.m_first, p.paid_from) - p.anchor, 0)`
+	return `CASE
+		WHEN p.billing_period = 'weekly' THEN
+			CASE WHEN ` + lastDay + ` - p.anchor < ` + skipped + ` THEN 0
+			     ELSE (` + lastDay + ` - p.anchor) / 7 - (` + skipped + ` + 6) / 7 + 1 END
+		WHEN ` + monthsSinceAnchor + ` % (CASE p.billing_period WHEN 'quarterly' THEN 3 WHEN 'yearly' THEN 12 ELSE 1 END) <> 0 THEN 0
+		WHEN ` + chargeDay + ` > ` + lastDay + ` THEN 0
+		WHEN ` + chargeDay + ` < p.paid_from THEN 0
+		ELSE 1 END::numeric`
+}
+
+// rateLookup returns a subquery yielding the rate of the currency in expr
+// for month g.month; the base currency ($6) always has rate 1.
+func rateLookup(expr string) string {
+	return fmt.Sprintf(`SELECT CASE WHEN %[1]s = $6 THEN 1::numeric ELSE (
+		SELECT er.rate FROM exchange_rates er
+		 WHERE er.currency = %[1]s AND er.month <= g.month
+		 ORDER BY er.month DESC LIMIT 1) END`, expr)
+}
+
+func (r *Repo) UpsertRates(ctx context.Context, rates []domain.ExchangeRate) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	batch := &pgx.Batch{}
+	for _, rt := range rates {
+		batch.Queue(`
+			INSERT INTO exchange_rates (currency, month, rate) VALUES ($1,$2,$3)
+			ON CONFLICT (currency, month) DO UPDATE SET rate = EXCLUDED.rate`,
+			rt.Currency, rt.Month, rt.Rate)
+	}
+	if err := r.db.SendBatch(ctx, batch).Close(); err != nil {
+		logger.Log.Errorf("upsert rates error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *Repo) ListRates(ctx context.Context) ([]domain.ExchangeRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rows, err := r.db.Query(ctx, `SELECT currency, month, rate::float8 FROM exchange_rates ORDER BY currency, month`)
+	if err != nil {
+		logger.Log.Errorf("list rates query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var out []domain.ExchangeRate
+	for rows.Next() {
+		var rt domain.ExchangeRate
+		if err := rows.Scan(&rt.Currency, &rt.Month, &rt.Rate); err != nil {
+			logger.Log.Errorf("list rates scan error: %v", err)
+			return nil, err
+		}
+		out = append(out, rt)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) SchedulePriceChange(ctx context.Context, pc domain.PriceChange) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO subscription_prices (subscription_id, effective_from, price)
+			SELECT id, $2, $3 FROM subscriptions WHERE id=$1 AND deleted_at IS NULL
+			ON CONFLICT (subscription_id, effective_from) DO UPDATE
+			   SET price = EXCLUDED.price, created_at = now()
+			RETURNING created_at`,
+			pc.SubscriptionID, pc.EffectiveFrom, pc.Price,
+		).Scan(&pc.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, pc.SubscriptionID, domain.EventPriceChange, nil, pc)
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("schedule price change exec error: %v", err)
+	}
+	return dbError(err)
+}
+
+func (r *Repo) ListPriceChanges(ctx context.Context, id uuid.UUID) ([]domain.PriceChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := r.db.Query(ctx, `
+		SELECT subscription_id, effective_from, price, created_at
+		  FROM subscription_prices
+		 WHERE subscription_id=$1
+		 ORDER BY effective_from`, id)
+	if err != nil {
+		logger.Log.Errorf("list price changes query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var out []domain.PriceChange
+	for rows.Next() {
+		var pc domain.PriceChange
+		if err := rows.Scan(&pc.SubscriptionID, &pc.EffectiveFrom, &pc.Price, &pc.CreatedAt); err != nil {
+			logger.Log.Errorf("list price changes scan error: %v", err)
+			return nil, err
+		}
+		out = append(out, pc)
+	}
+	return out, rows.Err()
+}
+
+// serviceColumns is the column list matching scanService.
+const serviceColumns = `id, name, aliases, category, default_price, currency, vendor_url, created_at, updated_at`
+
+func scanService(row pgx.Row) (domain.Service, error) {
+	var sv domain.Service
+	err := row.Scan(&sv.ID, &sv.Name, &sv.Aliases, &sv.Category, &sv.DefaultPrice, &sv.Currency, &sv.VendorURL, &sv.CreatedAt, &sv.UpdatedAt)
+	return sv, err
+}
+
+func (r *Repo) CreateService(ctx context.Context, sv domain.Service) (domain.Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var created domain.Service
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := lockServiceKeys(ctx, tx, sv.Keys(), false); err != nil {
+			return err
+		}
+		var err error
+		created, err = scanService(tx.QueryRow(ctx, `
+			INSERT INTO services (id, name, aliases, category, default_price, currency, vendor_url)
+			VALUES ($1,$2,$3,$4,$5,$6,$7)
+			RETURNING `+serviceColumns,
+			uuid.New(), sv.Name, sv.Aliases, sv.Category, sv.DefaultPrice, sv.Currency, sv.VendorURL,
+		))
+		if err != nil {
+			return err
+		}
+		if err := insertServiceKeys(ctx, tx, created); err != nil {
+			return err
+		}
+		return relinkTx(ctx, tx, created)
+	})
+	if err != nil {
+		logger.Log.Errorf("create service exec error: %v", err)
+	}
+	return created, dbError(err)
+}
+
+func (r *Repo) GetService(ctx context.Context, id uuid.UUID) (domain.Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	sv, err := scanService(r.db.QueryRow(ctx, `SELECT `+serviceColumns+` FROM services WHERE id=$1`, id))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("get service query error: %v", err)
+	}
+	return sv, dbError(err)
+}
+
+func (r *Repo) ListServices(ctx context.Context) ([]domain.Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rows, err := r.db.Query(ctx, `SELECT `+serviceColumns+` FROM services ORDER BY name, id`)
+	if err != nil {
+		logger.Log.Errorf("list services query error: %v", err)
+		return nil, err
+	}
+	out, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Service, error) {
+		return scanService(row)
+	})
+	if err != nil {
+		logger.Log.Errorf("list services scan error: %v", err)
+	}
+	return out, err
+}
+
+// UpdateService replaces a service and its keys. Subscriptions already
+// linked to it stay linked and take the new name.
+func (r *Repo) UpdateService(ctx context.Context, id uuid.UUID, sv domain.Service) (domain.Service, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var updated domain.Service
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		// Lock the keys being dropped too: a record resolving one of them
+		// now would be linked under the old name.
+		var old []string
+		if err := tx.QueryRow(ctx, `
+			SELECT COALESCE(array_agg(key), '{}') FROM service_keys WHERE service_id=$1`, id).Scan(&old); err != nil {
+			return err
+		}
+		if err := lockServiceKeys(ctx, tx, append(old, sv.Keys()...), false); err != nil {
+			return err
+		}
+		var err error
+		updated, err = scanService(tx.QueryRow(ctx, `
+			UPDATE services
+			   SET name=$2, aliases=$3, category=$4, default_price=$5, currency=$6, vendor_url=$7,
+			       updated_at=now()
+			 WHERE id=$1
+			RETURNING `+serviceColumns,
+			id, sv.Name, sv.Aliases, sv.Category, sv.DefaultPrice, sv.Currency, sv.VendorURL,
+		))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM service_keys WHERE service_id=$1`, id); err != nil {
+			return err
+		}
+		if err := insertServiceKeys(ctx, tx, updated); err != nil {
+			return err
+		}
+		return relinkTx(ctx, tx, updated)
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("update service exec error: %v", err)
+	}
+	return updated, dbError(err)
+}
+
+func (r *Repo) DeleteService(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var keys []string
+		if err := tx.QueryRow(ctx, `
+			SELECT COALESCE(array_agg(key), '{}') FROM service_keys WHERE service_id=$1`, id).Scan(&keys); err != nil {
+			return err
+		}
+		if err := lockServiceKeys(ctx, tx, keys, false); err != nil {
+			return err
+		}
+		if err := unlinkTx(ctx, tx, id); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `DELETE FROM services WHERE id=$1`, id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			logger.Log.Errorf("delete service exec error: %v", err)
+		}
+		return dbError(err)
+	}
+	return nil
+}
+
+// unlinkTx detaches the live subscriptions linked to the service id,
+// recording an update event for each. Deleted subscriptions and budgets are
+// detached by the foreign keys when the service row goes.
+func unlinkTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) error {
+	rows, err := tx.Query(ctx, `
+		SELECT `+subscriptionColumns+` FROM subscriptions
+		 WHERE service_id = $1 AND deleted_at IS NULL
+		 ORDER BY id
+		   FOR UPDATE`, id)
+	if err != nil {
+		return err
+	}
+	linked, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Subscription, error) {
+		return scanSubscription(row)
+	})
+	if err != nil {
+		return err
+	}
+	for _, before := range linked {
+		after, err := scanSubscription(tx.QueryRow(ctx, `
+			UPDATE subscriptions
+			   SET service_id=NULL, updated_at=now(), version=version+1
+			 WHERE id=$1
+			RETURNING `+subscriptionColumns, before.ID))
+		if err != nil {
+			return err
+		}
+		if err := insertEvent(ctx, tx, before.ID, domain.EventUpdate, before, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertServiceKeys(ctx context.Context, tx pgx.Tx, sv domain.Service) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO service_keys (key, service_id)
+		SELECT unnest($1::text[]), $2`, sv.Keys(), sv.ID)
+	return err
+}
+
+// relinkTx links the live subscriptions whose service_key is one of the
+// keys of sv and brings the names of the ones already linked to it up to
+// date, recording an update event for each, then does the same for
+// budgets. Callers must hold the exclusive lockServiceKeys lock on the
+// keys.
+func relinkTx(ctx context.Context, tx pgx.Tx, sv domain.Service) error {
+	rows, err := tx.Query(ctx, `
+		SELECT `+subscriptionColumns+` FROM subscriptions
+		 WHERE deleted_at IS NULL
+		   AND ((service_id IS NULL AND service_key = ANY($3))
+		     OR (service_id = $1 AND service_name <> $2))
+		 ORDER BY id
+		   FOR UPDATE`, sv.ID, sv.Name, sv.Keys())
+	if err != nil {
+		return err
+	}
+	stale, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Subscription, error) {
+		return scanSubscription(row)
+	})
+	if err != nil {
+		return err
+	}
+	for _, before := range stale {
+		after, err := scanSubscription(tx.QueryRow(ctx, `
+			UPDATE subscriptions
+			   SET service_id=$2, service_name=$3, service_key=$4, category=COALESCE(category, $5),
+			       updated_at=now(), version=version+1
+			 WHERE id=$1
+			RETURNING `+subscriptionColumns, before.ID, sv.ID, sv.Name, domain.ServiceKey(sv.Name), sv.Category))
+		if err != nil {
+			return err
+		}
+		if err := insertEvent(ctx, tx, before.ID, domain.EventUpdate, before, after); err != nil {
+			return err
+		}
+	}
+	return relinkBudgetsTx(ctx, tx, sv)
+}
+
+// relinkBudgetsTx is relinkTx for the budgets scoped to a service. A
+// budget whose new scope is already taken by another budget of the user
+// is left as it is.
+func relinkBudgetsTx(ctx context.Context, tx pgx.Tx, sv domain.Service) error {
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM budgets
+		 WHERE (service_id IS NULL AND service_key = ANY($3))
+		    OR (service_id = $1 AND service_name <> $2)
+		 ORDER BY id
+		   FOR UPDATE`, sv.ID, sv.Name, sv.Keys())
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		_, err := tx.Exec(ctx, `
+			UPDATE budgets b
+			   SET service_id=$2, service_name=$3, service_key=$4, updated_at=now()
+			 WHERE id=$1
+			   AND NOT EXISTS (
+			       SELECT 1 FROM budgets o
+			        WHERE o.id <> b.id AND o.user_id = b.user_id
+			          AND COALESCE(o.category, '') = COALESCE(b.category, '')
+			          AND o.service_name = $3)`, id, sv.ID, sv.Name, domain.ServiceKey(sv.Name))
+		if err != nil {
+			return err
+		}
 	}
-	logger.Log.Infof("summary total=%d", total)
-	return total, nil
+	return nil
+}
+
+// serviceKeyLockSpace namespaces the advisory locks of lockServiceKeys.
+const serviceKeyLockSpace int32 = 0x53564b // "SVK"
+
+// lockServiceKeys takes transaction-scoped advisory locks on keys. Catalog
+// writes take them exclusively and resolving a name takes them shared, so
+// a record resolved while a service with its key is being written is
+// either seen by that service's relink or sees the committed service.
+func lockServiceKeys(ctx context.Context, tx pgx.Tx, keys []string, shared bool) error {
+	ids := make([]int32, 0, len(keys))
+	for _, k := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(k))
+		ids = append(ids, int32(h.Sum32()))
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	fn := "pg_advisory_xact_lock"
+	if shared {
+		fn = "pg_advisory_xact_lock_shared"
+	}
+	_, err := tx.Exec(ctx, `SELECT `+fn+`($1, k) FROM unnest($2::int[]) WITH ORDINALITY AS t(k, n) ORDER BY n`,
+		serviceKeyLockSpace, ids)
+	return err
+}
+
+// serviceKeyOf returns the service_key stored for a nullable name.
+func serviceKeyOf(name *string) *string {
+	if name == nil {
+		return nil
+	}
+	k := domain.ServiceKey(*name)
+	return &k
+}
+
+// budgetColumns is the column list matching scanBudget.
+const budgetColumns = `id, user_id, category, service_name, service_id, monthly_limit, currency, created_at, updated_at`
+
+func scanBudget(row pgx.Row) (domain.Budget, error) {
+	var b domain.Budget
+	err := row.Scan(&b.ID, &b.UserID, &b.Category, &b.ServiceName, &b.ServiceID, &b.Limit, &b.Currency, &b.CreatedAt, &b.UpdatedAt)
+	return b, err
+}
+
+func (r *Repo) CreateBudget(ctx context.Context, b domain.Budget) (domain.Budget, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var created domain.Budget
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := resolveBudgetService(ctx, tx, &b); err != nil {
+			return err
+		}
+		var err error
+		created, err = scanBudget(tx.QueryRow(ctx, `
+			INSERT INTO budgets (id, user_id, category, service_name, service_id, monthly_limit, currency, service_key)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+			RETURNING `+budgetColumns,
+			uuid.New(), b.UserID, b.Category, b.ServiceName, b.ServiceID, b.Limit, b.Currency, serviceKeyOf(b.ServiceName),
+		))
+		return err
+	})
+	if err != nil {
+		logger.Log.Errorf("create budget exec error: %v", err)
+	}
+	return created, dbError(err)
+}
+
+func (r *Repo) GetBudget(ctx context.Context, id uuid.UUID) (domain.Budget, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	b, err := scanBudget(r.db.QueryRow(ctx, `SELECT `+budgetColumns+` FROM budgets WHERE id=$1`, id))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("get budget query error: %v", err)
+	}
+	return b, dbError(err)
+}
+
+func (r *Repo) ListBudgets(ctx context.Context, userID *uuid.UUID) ([]domain.Budget, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rows, err := r.db.Query(ctx, `
+		SELECT `+budgetColumns+` FROM budgets
+		 WHERE $1::uuid IS NULL OR user_id = $1
+		 ORDER BY user_id, category COLLATE "C" NULLS FIRST, service_name COLLATE "C" NULLS FIRST, id`, userID)
+	if err != nil {
+		logger.Log.Errorf("list budgets query error: %v", err)
+		return nil, err
+	}
+	out, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Budget, error) {
+		return scanBudget(row)
+	})
+	if err != nil {
+		logger.Log.Errorf("list budgets scan error: %v", err)
+	}
+	return out, err
+}
+
+func (r *Repo) UpdateBudget(ctx context.Context, id uuid.UUID, b domain.Budget) (domain.Budget, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var updated domain.Budget
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := resolveBudgetService(ctx, tx, &b); err != nil {
+			return err
+		}
+		var err error
+		updated, err = scanBudget(tx.QueryRow(ctx, `
+			UPDATE budgets
+			   SET user_id=$2, category=$3, service_name=$4, service_id=$5, monthly_limit=$6, currency=$7,
+			       service_key=$8, updated_at=now()
+			 WHERE id=$1
+			RETURNING `+budgetColumns,
+			id, b.UserID, b.Category, b.ServiceName, b.ServiceID, b.Limit, b.Currency, serviceKeyOf(b.ServiceName),
+		))
+		return err
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logger.Log.Errorf("update budget exec error: %v", err)
+	}
+	return updated, dbError(err)
+}
+
+func (r *Repo) DeleteBudget(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tag, err := r.db.Exec(ctx, `DELETE FROM budgets WHERE id=$1`, id)
+	if err != nil {
+		logger.Log.Errorf("delete budget exec error: %v", err)
+		return dbError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repo) Pause(ctx context.Context, p domain.Pause) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := lockSubscription(ctx, tx, p.SubscriptionID, 0); err != nil {
+			return err
+		}
+		var overlaps bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM subscription_pauses
+				 WHERE subscription_id = $1
+				   AND paused_from <= COALESCE($3::date, 'infinity')
+				   AND COALESCE(paused_until, 'infinity') >= $2::date)`,
+			p.SubscriptionID, p.From, p.Until,
+		).Scan(&overlaps)
+		if err != nil {
+			return err
+		}
+		if overlaps {
+			return ErrPauseOverlap
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO subscription_pauses (subscription_id, paused_from, paused_until)
+			VALUES ($1,$2,$3)
+			RETURNING created_at`,
+			p.SubscriptionID, p.From, p.Until,
+		).Scan(&p.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, p.SubscriptionID, domain.EventPause, nil, p)
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) && !errors.Is(err, ErrPauseOverlap) {
+		logger.Log.Errorf("pause exec error: %v", err)
+	}
+	return dbError(err)
+}
+
+func (r *Repo) Resume(ctx context.Context, id uuid.UUID, month time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := lockSubscription(ctx, tx, id, 0); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, `
+			SELECT subscription_id, paused_from, paused_until, created_at
+			  FROM subscription_pauses
+			 WHERE subscription_id = $1 AND paused_from <= $2::date
+			   AND (paused_until IS NULL OR paused_until >= $2::date)`, id, month)
+		if err != nil {
+			return err
+		}
+		before, err := pgx.CollectExactlyOneRow(rows, scanPause)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotPaused
+		}
+		if err != nil {
+			return err
+		}
+		if !before.From.Before(month) {
+			if _, err := tx.Exec(ctx, `
+				DELETE FROM subscription_pauses WHERE subscription_id = $1 AND paused_from = $2`,
+				id, before.From); err != nil {
+				return err
+			}
+			return insertEvent(ctx, tx, id, domain.EventResume, before, nil)
+		}
+		after := before
+		until := month.AddDate(0, -1, 0)
+		after.Until = &until
+		if _, err := tx.Exec(ctx, `
+			UPDATE subscription_pauses SET paused_until = $3
+			 WHERE subscription_id = $1 AND paused_from = $2`,
+			id, before.From, until); err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, id, domain.EventResume, before, after)
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) && !errors.Is(err, ErrNotPaused) {
+		logger.Log.Errorf("resume exec error: %v", err)
+	}
+	return dbError(err)
+}
+
+func (r *Repo) ListPauses(ctx context.Context, id uuid.UUID) ([]domain.Pause, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := r.db.Query(ctx, `
+		SELECT subscription_id, paused_from, paused_until, created_at
+		  FROM subscription_pauses
+		 WHERE subscription_id = $1
+		 ORDER BY paused_from`, id)
+	if err != nil {
+		logger.Log.Errorf("list pauses query error: %v", err)
+		return nil, err
+	}
+	out, err := pgx.CollectRows(rows, scanPause)
+	if err != nil {
+		logger.Log.Errorf("list pauses scan error: %v", err)
+	}
+	return out, err
+}
+
+func scanPause(row pgx.CollectableRow) (domain.Pause, error) {
+	var p domain.Pause
+	err := row.Scan(&p.SubscriptionID, &p.From, &p.Until, &p.CreatedAt)
+	return p, err
 }
@@ -0,0 +1,266 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+)
+
+// Errors returned by Store implementations. Callers should match them
+// with errors.Is; the more specific errors below wrap one of these.
+var (
+	// ErrNotFound means the record does not exist or is deleted.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the change clashes with the current stored state.
+	ErrConflict = errors.New("conflict")
+	// ErrValidation means the data was rejected by a storage constraint.
+	ErrValidation = errors.New("validation failed")
+)
+
+// ErrMissingRate is returned by Summary when a charge cannot be converted
+// to the requested currency because no exchange rate is known for it.
+var ErrMissingRate = fmt.Errorf("%w: missing exchange rate", ErrValidation)
+
+// ErrVersionMismatch is returned by Update and Delete when the stored
+// version differs from the one the caller expected.
+var ErrVersionMismatch = fmt.Errorf("%w: version mismatch", ErrConflict)
+
+// ErrPriceChange is returned by Update when the new price differs from the
+// stored one: past totals depend on it, so prices change through
+// SchedulePriceChange instead.
+var ErrPriceChange = fmt.Errorf("%w: price changed in place", ErrValidation)
+
+// ErrCurrencyChange, ErrBillingPeriodChange and ErrStartChange are returned
+// by Update when s changes the currency, billing period or start of the
+// subscription: like the price, past totals depend on them.
+var (
+	ErrCurrencyChange      = fmt.Errorf("%w: currency changed in place", ErrValidation)
+	ErrBillingPeriodChange = fmt.Errorf("%w: billing period changed in place", ErrValidation)
+	ErrStartChange         = fmt.Errorf("%w: start changed in place", ErrValidation)
+)
+
+// checkFixedTerms returns the error Update reports when s changes a term of
+// cur that past totals depend on, or nil.
+func checkFixedTerms(cur, s domain.Subscription) error {
+	switch {
+	case s.Price != cur.Price:
+		return ErrPriceChange
+	case s.Currency != cur.Currency:
+		return ErrCurrencyChange
+	case s.BillingPeriod != cur.BillingPeriod:
+		return ErrBillingPeriodChange
+	case !s.StartMonth.Equal(cur.StartMonth) || !equalTime(s.StartDate, cur.StartDate):
+		return ErrStartChange
+	}
+	return nil
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// ErrIdempotencyKeyReused is returned by CreateIdempotent when the key was
+// already used for a different request.
+var ErrIdempotencyKeyReused = fmt.Errorf("%w: idempotency key reused with a different request", ErrValidation)
+
+// ErrPauseOverlap is returned by Pause when the new pause shares a month
+// with an existing one.
+var ErrPauseOverlap = fmt.Errorf("%w: overlaps another pause", ErrConflict)
+
+// ErrNotPaused is returned by Resume when the subscription is not paused
+// in the given month.
+var ErrNotPaused = fmt.Errorf("%w: not paused", ErrConflict)
+
+// ErrBatchAborted is reported for the operations of an atomic batch that
+// were rolled back because another operation failed.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// Store is the persistence contract used by the HTTP layer.
+// Repo (Postgres) and Memory are the available implementations.
+type Store interface {
+	Create(ctx context.Context, s domain.Subscription) (uuid.UUID, error)
+	// CreateIdempotent creates s unless key was already used for the same
+	// request, in which case it returns the id created then and replayed
+	// set to true.
+	CreateIdempotent(ctx context.Context, key IdempotencyKey, s domain.Subscription) (id uuid.UUID, replayed bool, err error)
+	Get(ctx context.Context, id uuid.UUID) (domain.Subscription, error)
+	// ListFiltered returns a page of subscriptions and, when f.CountTotal is
+	// set, the number of all subscriptions matching f regardless of paging.
+	ListFiltered(ctx context.Context, f ListFilter, limit, offset int) ([]domain.Subscription, int, error)
+	// Update and Delete take the version the caller last saw; 0 skips the
+	// optimistic concurrency check. Update never changes the price,
+	// currency, billing period or start and returns ErrPriceChange,
+	// ErrCurrencyChange, ErrBillingPeriodChange or ErrStartChange if s asks to.
+	Update(ctx context.Context, id uuid.UUID, s domain.Subscription, ifVersion int) error
+	Delete(ctx context.Context, id uuid.UUID, ifVersion int) error
+	Restore(ctx context.Context, id uuid.UUID) error
+	Purge(ctx context.Context, cutoff time.Time) (int, error)
+	// Batch runs ops in order. With atomic set either every operation is
+	// committed or none is; otherwise each one succeeds or fails on its own.
+	// Per-operation failures are reported in the results, not as the error.
+	Batch(ctx context.Context, ops []BatchOp, atomic bool) ([]BatchResult, error)
+	Summary(ctx context.Context, f SummaryFilter) (domain.SummaryResult, error)
+	UpsertRates(ctx context.Context, rates []domain.ExchangeRate) error
+	ListRates(ctx context.Context) ([]domain.ExchangeRate, error)
+	SchedulePriceChange(ctx context.Context, pc domain.PriceChange) error
+	ListPriceChanges(ctx context.Context, id uuid.UUID) ([]domain.PriceChange, error)
+	History(ctx context.Context, id uuid.UUID) ([]domain.SubscriptionEvent, error)
+	// Pause records a pause of a live subscription. Resume ends the pause
+	// covering month so that the subscription is charged again from it; a
+	// pause that would become empty is removed.
+	Pause(ctx context.Context, p domain.Pause) error
+	Resume(ctx context.Context, id uuid.UUID, month time.Time) error
+	ListPauses(ctx context.Context, id uuid.UUID) ([]domain.Pause, error)
+	// CreateService and UpdateService fail with ErrConflict when a name or
+	// alias already belongs to another service. Both link live subscriptions
+	// whose service_name matches and rename them to the canonical name.
+	CreateService(ctx context.Context, sv domain.Service) (domain.Service, error)
+	GetService(ctx context.Context, id uuid.UUID) (domain.Service, error)
+	ListServices(ctx context.Context) ([]domain.Service, error)
+	UpdateService(ctx context.Context, id uuid.UUID, sv domain.Service) (domain.Service, error)
+	// DeleteService removes a service and unlinks its subscriptions; their
+	// service_name is kept.
+	DeleteService(ctx context.Context, id uuid.UUID) error
+	// CreateBudget and UpdateBudget fail with ErrConflict when the user
+	// already has a budget with the same scope.
+	CreateBudget(ctx context.Context, b domain.Budget) (domain.Budget, error)
+	GetBudget(ctx context.Context, id uuid.UUID) (domain.Budget, error)
+	// ListBudgets returns the budgets of userID, or all of them when nil.
+	ListBudgets(ctx context.Context, userID *uuid.UUID) ([]domain.Budget, error)
+	UpdateBudget(ctx context.Context, id uuid.UUID, b domain.Budget) (domain.Budget, error)
+	DeleteBudget(ctx context.Context, id uuid.UUID) error
+	Close()
+}
+
+type SummaryFilter struct {
+	UserID      *uuid.UUID
+	ServiceName *string
+	// Category and Tag keep subscriptions with that (normalized) category
+	// or tag.
+	Category *string
+	Tag      *string
+	From, To time.Time
+	GroupBy  []domain.SummaryGroup
+	// Currency is the currency totals are reported in; BaseCurrency is the
+	// one exchange rates are quoted against.
+	Currency     string
+	BaseCurrency string
+	Basis        domain.SummaryBasis
+	// IncludeDeleted also counts soft-deleted subscriptions.
+	IncludeDeleted bool
+}
+
+// ListFilter narrows and orders ListFiltered. Nil and zero fields do not
+// filter.
+type ListFilter struct {
+	UserIDs      []uuid.UUID
+	ServiceName  *string
+	ServiceMatch ServiceMatch
+	Category     *string
+	// Tags keeps subscriptions having all of them.
+	Tags []string
+	// PriceMin and PriceMax bound the price in the subscription's own
+	// minor units, inclusive.
+	PriceMin, PriceMax *int
+	// ActiveIn keeps subscriptions active at some point of that month.
+	ActiveIn *time.Time
+	// StartedFrom..StartedTo and EndedFrom..EndedTo bound the first and the
+	// last active day, inclusive.
+	StartedFrom, StartedTo *time.Time
+	EndedFrom, EndedTo     *time.Time
+	// TrialEndsFrom..TrialEndsTo bound the last day of the trial, inclusive;
+	// subscriptions without a trial never match.
+	TrialEndsFrom, TrialEndsTo *time.Time
+	// OpenEnded keeps only subscriptions without (true) or with (false)
+	// an end date.
+	OpenEnded      *bool
+	IncludeDeleted bool
+	CountTotal     bool
+	Sort           ListSort
+	// Cursor switches ListFiltered to keyset pagination; offset is then
+	// ignored. It requires the default sort.
+	Cursor *ListCursor
+}
+
+// ServiceMatch says how ListFilter.ServiceName is compared. All modes but
+// the default exact one ignore case.
+type ServiceMatch string
+
+const (
+	MatchExact    ServiceMatch = ""
+	MatchIExact   ServiceMatch = "iexact"
+	MatchPrefix   ServiceMatch = "prefix"
+	MatchContains ServiceMatch = "contains"
+)
+
+type ListSortField string
+
+const (
+	SortCreatedAt   ListSortField = "created_at"
+	SortPrice       ListSortField = "price"
+	SortStartMonth  ListSortField = "start_month"
+	SortServiceName ListSortField = "service_name"
+)
+
+// ListSort orders the list; ties are broken by id in the same direction.
+// The zero value is created_at descending.
+type ListSort struct {
+	Field ListSortField
+	Asc   bool
+}
+
+// IsDefault reports whether s is the created_at descending order that
+// cursors are defined on.
+func (s ListSort) IsDefault() bool {
+	return (s.Field == "" || s.Field == SortCreatedAt) && !s.Asc
+}
+
+// ListCursor is a position in the list order (created_at DESC, id DESC).
+// The page holds the items after it, or the ones just before it when
+// Backward is set; either way they are returned in list order.
+type ListCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+	Backward  bool
+}
+
+type BatchAction string
+
+const (
+	BatchCreate BatchAction = "create"
+	BatchUpdate BatchAction = "update"
+	BatchDelete BatchAction = "delete"
+)
+
+// BatchOp is a single operation of a batch. ID is ignored for creates;
+// Sub is ignored for deletes.
+type BatchOp struct {
+	Action    BatchAction
+	ID        uuid.UUID
+	Sub       domain.Subscription
+	IfVersion int
+}
+
+// BatchResult is the outcome of the BatchOp at the same index: the
+// subscription after the operation, or the error that stopped it.
+type BatchResult struct {
+	Sub domain.Subscription
+	Err error
+}
+
+// IdempotencyKey identifies a client request that must be executed at most
+// once. RequestHash tells retries apart from a different request reusing
+// the key.
+type IdempotencyKey struct {
+	Key         string
+	RequestHash string
+	ExpiresAt   time.Time
+}
@@ -0,0 +1,222 @@
+package repo
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+	"github.com/pavel97go/subscriptions/internal/util"
+)
+
+// summaryKey identifies a summary group; the category and tag of
+// subscriptions without one are empty.
+type summaryKey struct {
+	service  string
+	user     uuid.UUID
+	month    time.Time
+	category string
+	tag      string
+}
+
+// summaryGroupOrder is the order of the group columns and of the rows
+// sorted by them.
+var summaryGroupOrder = []domain.SummaryGroup{
+	domain.GroupByMonth, domain.GroupByService, domain.GroupByUser, domain.GroupByCategory, domain.GroupByTag,
+}
+
+// summaryLookup provides the time-dependent inputs of a summary.
+type summaryLookup interface {
+	// rateAt returns the rate of currency for month against the base
+	// currency, reporting false when no rate is known.
+	rateAt(currency string, month time.Time) (float64, bool)
+	// priceAt returns the price of s in effect during month.
+	priceAt(s domain.Subscription, month time.Time) int
+	// pausedIn reports whether s is paused during month.
+	pausedIn(s domain.Subscription, month time.Time) bool
+}
+
+// summaryAgg accumulates subscription costs over SummaryFilter's period in
+// the filter's currency, optionally split by the requested groups. It is
+// the Go counterpart of the aggregation Repo.Summary runs in SQL.
+type summaryAgg struct {
+	f      SummaryFilter
+	lookup summaryLookup
+	groups map[summaryKey]float64
+	// shares holds what each group contributes to the overall total; see
+	// domain.GroupByTag.
+	shares  map[summaryKey]float64
+	missing int
+}
+
+func newSummaryAgg(f SummaryFilter, lookup summaryLookup) *summaryAgg {
+	return &summaryAgg{f: f, lookup: lookup, groups: map[summaryKey]float64{}, shares: map[summaryKey]float64{}}
+}
+
+// includes reports whether s passes the filter fields other than the
+// period and deletion, which callers check.
+func (f SummaryFilter) includes(s domain.Subscription) bool {
+	if f.UserID != nil && s.UserID != *f.UserID {
+		return false
+	}
+	if f.ServiceName != nil && s.ServiceName != *f.ServiceName {
+		return false
+	}
+	if f.Category != nil && (s.Category == nil || *s.Category != *f.Category) {
+		return false
+	}
+	if f.Tag != nil && !slices.Contains(s.Tags, *f.Tag) {
+		return false
+	}
+	return true
+}
+
+func (a *summaryAgg) add(s domain.Subscription) {
+	months := util.MonthsOverlap(s.StartMonth, s.EndMonth, a.f.From, a.f.To)
+	if months == 0 {
+		return
+	}
+	var k summaryKey
+	if a.f.grouped(domain.GroupByService) {
+		k.service = s.ServiceName
+	}
+	if a.f.grouped(domain.GroupByUser) {
+		k.user = s.UserID
+	}
+	if a.f.grouped(domain.GroupByCategory) && s.Category != nil {
+		k.category = *s.Category
+	}
+	tags := []string{""}
+	if a.f.grouped(domain.GroupByTag) && len(s.Tags) > 0 {
+		tags = s.Tags
+	}
+	m := a.f.From
+	if s.StartMonth.After(m) {
+		m = s.StartMonth
+	}
+	for ; months > 0; months, m = months-1, m.AddDate(0, 1, 0) {
+		qty := a.qty(s, m)
+		if qty == 0 || a.lookup.pausedIn(s, m) {
+			continue
+		}
+		if a.f.grouped(domain.GroupByMonth) {
+			k.month = m
+		}
+		amount, ok := a.convert(a.lookup.priceAt(s, m), s.Currency, m)
+		if !ok {
+			a.missing++
+		}
+		for i, tag := range tags {
+			k.tag = tag
+			a.groups[k] += qty * amount
+			if i == 0 {
+				a.shares[k] += qty * amount
+			}
+		}
+	}
+}
+
+// qty is the number of charges of s falling into month, or its monthly
+// equivalent share for the other bases. Trial days are free.
+func (a *summaryAgg) qty(s domain.Subscription, month time.Time) float64 {
+	paidFrom := s.PaidFrom()
+	switch a.f.Basis {
+	case domain.BasisMonthly:
+		if paidFrom.After(month.AddDate(0, 1, -1)) {
+			return 0
+		}
+		return s.BillingPeriod.MonthlyFactor()
+	case domain.BasisProrated:
+		return s.BillingPeriod.MonthlyFactor() * domain.ActiveShare(paidFrom, s.LastDay(), month)
+	default:
+		return float64(s.BillingPeriod.PaidChargesIn(s.Anchor(), paidFrom, s.LastDay(), month))
+	}
+}
+
+func (a *summaryAgg) convert(amount int, currency string, month time.Time) (float64, bool) {
+	if currency == a.f.Currency {
+		return float64(amount), true
+	}
+	from, ok := a.rateOf(currency, month)
+	if !ok {
+		return 0, false
+	}
+	to, ok := a.rateOf(a.f.Currency, month)
+	if !ok {
+		return 0, false
+	}
+	return util.ConvertMinor(amount,
+		domain.CurrencyExponents[currency], domain.CurrencyExponents[a.f.Currency], from, to), true
+}
+
+func (a *summaryAgg) rateOf(currency string, month time.Time) (float64, bool) {
+	if currency == a.f.BaseCurrency {
+		return 1, true
+	}
+	return a.lookup.rateAt(currency, month)
+}
+
+func (a *summaryAgg) result() (domain.SummaryResult, error) {
+	if a.missing > 0 {
+		logger.Log.Warnf("summary: %d monthly charges have no exchange rate to %s", a.missing, a.f.Currency)
+		return domain.SummaryResult{}, ErrMissingRate
+	}
+	res := domain.SummaryResult{Currency: a.f.Currency}
+	keys := make([]summaryKey, 0, len(a.groups))
+	for k := range a.groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].month.Equal(keys[j].month) {
+			return keys[i].month.Before(keys[j].month)
+		}
+		if keys[i].service != keys[j].service {
+			return keys[i].service < keys[j].service
+		}
+		if c := strings.Compare(keys[i].user.String(), keys[j].user.String()); c != 0 {
+			return c < 0
+		}
+		if keys[i].category != keys[j].category {
+			return keys[i].category < keys[j].category
+		}
+		return keys[i].tag < keys[j].tag
+	})
+	grouped := len(a.f.GroupBy) > 0
+	for _, k := range keys {
+		res.Total += int(math.Round(a.shares[k]))
+		if grouped {
+			res.Rows = append(res.Rows, a.f.row(k, int(math.Round(a.groups[k]))))
+		}
+	}
+	return res, nil
+}
+
+func (f SummaryFilter) grouped(g domain.SummaryGroup) bool {
+	return slices.Contains(f.GroupBy, g)
+}
+
+// row builds a SummaryRow exposing only the requested group columns.
+func (f SummaryFilter) row(k summaryKey, total int) domain.SummaryRow {
+	r := domain.SummaryRow{Total: total}
+	if f.grouped(domain.GroupByService) {
+		r.ServiceName = &k.service
+	}
+	if f.grouped(domain.GroupByUser) {
+		r.UserID = &k.user
+	}
+	if f.grouped(domain.GroupByMonth) {
+		r.Month = &k.month
+	}
+	if f.grouped(domain.GroupByCategory) && k.category != "" {
+		r.Category = &k.category
+	}
+	if f.grouped(domain.GroupByTag) && k.tag != "" {
+		r.Tag = &k.tag
+	}
+	return r
+}
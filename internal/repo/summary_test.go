@@ -0,0 +1,360 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/pavel97go/subscriptions/internal/domain"
+	"github.com/pavel97go/subscriptions/internal/logger"
+	"github.com/pavel97go/subscriptions/internal/util"
+)
+
+// The SQL side of these tests needs a scratch database: set TEST_DB_DSN to
+// run it. Every run migrates a fresh schema and drops it afterwards.
+
+func TestMain(m *testing.M) {
+	logger.Log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+var (
+	summaryUser = uuid.MustParse("60601fee-2bf1-4721-ae6f-7636e79a0cba")
+	// detailUser holds the fixtures of the day-precision, trial, pause,
+	// currency and label cases.
+	detailUser = uuid.MustParse("8a1f3c52-94d1-4b7e-a0c6-2f4d9e7b1a03")
+)
+
+func month(s string) time.Time {
+	m, err := util.ParseMonth(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func monthPtr(s string) *time.Time {
+	m := month(s)
+	return &m
+}
+
+func datePtr(s string) *time.Time {
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return &d
+}
+
+// seedSummary stores the equivalence fixtures: for summaryUser an
+// open-ended subscription with a later price change, a single-month one and
+// subscriptions billed less often than monthly; for detailUser the ones
+// seedDetails adds.
+func seedSummary(tb testing.TB, st Store) {
+	tb.Helper()
+	ctx := context.Background()
+	subs := []domain.Subscription{
+		{ServiceName: "Open", Price: 1000, BillingPeriod: domain.BillingMonthly, StartMonth: month("03-2025")},
+		{ServiceName: "Single", Price: 500, BillingPeriod: domain.BillingMonthly, StartMonth: month("06-2025"), EndMonth: monthPtr("06-2025")},
+		{ServiceName: "Yearly", Price: 12000, BillingPeriod: domain.BillingYearly, StartMonth: month("02-2025")},
+		{ServiceName: "Quarterly", Price: 3000, BillingPeriod: domain.BillingQuarterly, StartMonth: month("01-2025"), EndMonth: monthPtr("08-2025")},
+	}
+	for _, s := range subs {
+		s.Currency, s.UserID = "RUB", summaryUser
+		id, err := st.Create(ctx, s)
+		if err != nil {
+			tb.Fatalf("create %s: %v", s.ServiceName, err)
+		}
+		if s.ServiceName == "Open" {
+			pc := domain.PriceChange{SubscriptionID: id, EffectiveFrom: month("09-2025"), Price: 1500}
+			if err := st.SchedulePriceChange(ctx, pc); err != nil {
+				tb.Fatalf("schedule price: %v", err)
+			}
+		}
+	}
+	seedDetails(tb, st)
+}
+
+// seedDetails stores for detailUser a weekly subscription ending mid-month,
+// a monthly one anchored on the 31st, one with a trial and a pause, and one
+// priced in USD, with categories and tags spread over them.
+func seedDetails(tb testing.TB, st Store) {
+	tb.Helper()
+	ctx := context.Background()
+	rates := []domain.ExchangeRate{{Currency: "USD", Month: month("01-2025"), Rate: 90}}
+	if err := st.UpsertRates(ctx, rates); err != nil {
+		tb.Fatalf("upsert rates: %v", err)
+	}
+	subs := []domain.Subscription{
+		{ServiceName: "Weekly", Price: 100, Currency: "RUB", BillingPeriod: domain.BillingWeekly,
+			StartMonth: month("01-2025"), StartDate: datePtr("2025-01-01"),
+			EndMonth: monthPtr("03-2025"), EndDate: datePtr("2025-03-15")},
+		{ServiceName: "Dated", Price: 3100, Currency: "RUB", BillingPeriod: domain.BillingMonthly,
+			Category: ptr("software"), Tags: []string{"work"},
+			StartMonth: month("01-2025"), StartDate: datePtr("2025-01-31"),
+			EndMonth: monthPtr("04-2025"), EndDate: datePtr("2025-04-15")},
+		{ServiceName: "Trial", Price: 1000, Currency: "RUB", BillingPeriod: domain.BillingMonthly,
+			Category: ptr("entertainment"), Tags: []string{"family", "video"},
+			StartMonth: month("02-2025"), StartDate: datePtr("2025-02-10"), TrialUntil: datePtr("2025-03-20")},
+		{ServiceName: "Foreign", Price: 1000, Currency: "USD", BillingPeriod: domain.BillingMonthly,
+			Category: ptr("entertainment"), Tags: []string{"video"},
+			StartMonth: month("01-2025"), EndMonth: monthPtr("03-2025")},
+	}
+	for _, s := range subs {
+		s.UserID = detailUser
+		id, err := st.Create(ctx, s)
+		if err != nil {
+			tb.Fatalf("create %s: %v", s.ServiceName, err)
+		}
+		if s.ServiceName == "Trial" {
+			p := domain.Pause{SubscriptionID: id, From: month("06-2025"), Until: monthPtr("07-2025")}
+			if err := st.Pause(ctx, p); err != nil {
+				tb.Fatalf("pause: %v", err)
+			}
+		}
+	}
+}
+
+func ptr(s string) *string { return &s }
+
+type summaryCase struct {
+	name     string
+	from, to string
+	// user defaults to summaryUser and currency to RUB.
+	user     *uuid.UUID
+	service  string
+	currency string
+	basis    domain.SummaryBasis
+	groupBy  []domain.SummaryGroup
+	total    int
+	// rows, when set, are the rows Memory must return.
+	rows    []string
+	wantErr error
+}
+
+var summaryCases = []summaryCase{
+	{name: "year", from: "01-2025", to: "12-2025", total: 6*1000 + 4*1500 + 500 + 12000 + 3*3000},
+	{name: "open-ended beyond last change", from: "01-2026", to: "03-2026", total: 3*1500 + 12000},
+	{name: "from equals to", from: "06-2025", to: "06-2025", total: 1000 + 500},
+	{name: "from equals to, quarterly charge", from: "07-2025", to: "07-2025", total: 1000 + 3000},
+	{name: "before all subscriptions", from: "12-2024", to: "12-2024", total: 0},
+	{name: "single month by service", from: "05-2025", to: "07-2025", groupBy: []domain.SummaryGroup{domain.GroupByService}, total: 3*1000 + 500 + 3000},
+	{name: "by month", from: "01-2025", to: "04-2025", groupBy: []domain.SummaryGroup{domain.GroupByMonth}, total: 2*1000 + 12000 + 2*3000},
+	{name: "monthly equivalent", from: "06-2025", to: "06-2025", basis: domain.BasisMonthly, total: 1000 + 500 + 1000 + 1000},
+	{name: "prorated by month and service", from: "02-2025", to: "03-2025", basis: domain.BasisProrated,
+		groupBy: []domain.SummaryGroup{domain.GroupByMonth, domain.GroupByService}, total: 1000 + 2*1000 + 2*1000},
+	{name: "weekly charges by month", user: &detailUser, service: "Weekly", from: "01-2025", to: "03-2025",
+		groupBy: []domain.SummaryGroup{domain.GroupByMonth}, total: (5 + 4 + 2) * 100,
+		rows: []string{"01-2025=500", "02-2025=400", "03-2025=200"}},
+	{name: "weekly monthly equivalent", user: &detailUser, service: "Weekly", from: "02-2025", to: "02-2025",
+		basis: domain.BasisMonthly, total: 433},
+	{name: "day anchor clamped to month end", user: &detailUser, service: "Dated", from: "01-2025", to: "04-2025",
+		groupBy: []domain.SummaryGroup{domain.GroupByMonth}, total: 3 * 3100,
+		rows: []string{"01-2025=3100", "02-2025=3100", "03-2025=3100"}},
+	{name: "day precision prorated", user: &detailUser, service: "Dated", from: "01-2025", to: "04-2025",
+		basis: domain.BasisProrated, groupBy: []domain.SummaryGroup{domain.GroupByMonth}, total: 100 + 3100 + 3100 + 1550,
+		rows: []string{"01-2025=100", "02-2025=3100", "03-2025=3100", "04-2025=1550"}},
+	{name: "trial and pause", user: &detailUser, service: "Trial", from: "01-2025", to: "12-2025",
+		groupBy: []domain.SummaryGroup{domain.GroupByMonth}, total: 7 * 1000,
+		rows: []string{"04-2025=1000", "05-2025=1000", "08-2025=1000", "09-2025=1000", "10-2025=1000", "11-2025=1000", "12-2025=1000"}},
+	{name: "trial prorated", user: &detailUser, service: "Trial", from: "03-2025", to: "06-2025",
+		basis: domain.BasisProrated, total: 355 + 2*1000},
+	{name: "converted from USD", user: &detailUser, service: "Foreign", from: "01-2025", to: "06-2025", total: 3 * 90000},
+	{name: "converted to USD", user: &detailUser, service: "Dated", from: "02-2025", to: "02-2025", currency: "USD",
+		total: 34},
+	{name: "missing rate", user: &detailUser, from: "01-2025", to: "01-2025", currency: "EUR", wantErr: ErrMissingRate},
+	{name: "by tag and category", user: &detailUser, from: "01-2025", to: "04-2025",
+		groupBy: []domain.SummaryGroup{domain.GroupByTag, domain.GroupByCategory}, total: 1100 + 9300 + 1000 + 3*90000,
+		rows: []string{"=1100", "entertainment/family=1000", "entertainment/video=271000", "software/work=9300"}},
+}
+
+func (c summaryCase) filter() SummaryFilter {
+	f := SummaryFilter{
+		UserID: &summaryUser, From: month(c.from), To: month(c.to), GroupBy: c.groupBy,
+		Currency: "RUB", BaseCurrency: "RUB", Basis: c.basis,
+	}
+	if c.user != nil {
+		f.UserID = c.user
+	}
+	if c.service != "" {
+		f.ServiceName = &c.service
+	}
+	if c.currency != "" {
+		f.Currency = c.currency
+	}
+	if f.Basis == "" {
+		f.Basis = domain.BasisCharges
+	}
+	return f
+}
+
+// summaryRows renders rows comparably: Postgres and Memory return months
+// in different locations.
+func summaryRows(res domain.SummaryResult) []string {
+	out := make([]string, 0, len(res.Rows))
+	for _, r := range res.Rows {
+		var parts []string
+		if r.Month != nil {
+			parts = append(parts, util.MonthStr(*r.Month))
+		}
+		if r.ServiceName != nil {
+			parts = append(parts, *r.ServiceName)
+		}
+		if r.Category != nil {
+			parts = append(parts, *r.Category)
+		}
+		if r.Tag != nil {
+			parts = append(parts, *r.Tag)
+		}
+		out = append(out, fmt.Sprintf("%s=%d", strings.Join(parts, "/"), r.Total))
+	}
+	return out
+}
+
+func TestSummaryEquivalence(t *testing.T) {
+	mem := NewMemory()
+	seedSummary(t, mem)
+	var pg *Repo
+	if os.Getenv("TEST_DB_DSN") != "" {
+		pg = newTestRepo(t)
+		seedSummary(t, pg)
+	}
+	ctx := context.Background()
+	for _, c := range summaryCases {
+		t.Run(c.name, func(t *testing.T) {
+			want, err := mem.Summary(ctx, c.filter())
+			if c.wantErr != nil {
+				if !errors.Is(err, c.wantErr) {
+					t.Errorf("memory error = %v, want %v", err, c.wantErr)
+				}
+				if pg == nil {
+					return
+				}
+				if _, err := pg.Summary(ctx, c.filter()); !errors.Is(err, c.wantErr) {
+					t.Errorf("postgres error = %v, want %v", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("memory summary: %v", err)
+			}
+			if want.Total != c.total {
+				t.Errorf("memory total = %d, want %d", want.Total, c.total)
+			}
+			if c.rows != nil {
+				if g := summaryRows(want); !reflect.DeepEqual(g, c.rows) {
+					t.Errorf("memory rows = %v, want %v", g, c.rows)
+				}
+			}
+			if pg == nil {
+				return
+			}
+			got, err := pg.Summary(ctx, c.filter())
+			if err != nil {
+				t.Fatalf("postgres summary: %v", err)
+			}
+			if got.Total != want.Total {
+				t.Errorf("postgres total = %d, memory %d", got.Total, want.Total)
+			}
+			if g, w := summaryRows(got), summaryRows(want); !reflect.DeepEqual(g, w) {
+				t.Errorf("postgres rows = %v, memory %v", g, w)
+			}
+		})
+	}
+}
+
+// seedBench stores five years of history over 50 services.
+func seedBench(b *testing.B, st Store) {
+	b.Helper()
+	ctx := context.Background()
+	periods := []domain.BillingPeriod{domain.BillingWeekly, domain.BillingMonthly, domain.BillingQuarterly, domain.BillingYearly}
+	for i := range 50 {
+		s := domain.Subscription{
+			ServiceName:   fmt.Sprintf("Service %02d", i),
+			Price:         100 * (i + 1),
+			Currency:      "RUB",
+			BillingPeriod: periods[i%len(periods)],
+			UserID:        summaryUser,
+			StartMonth:    month("01-2021").AddDate(0, i%12, 0),
+		}
+		if i%3 == 0 {
+			end := s.StartMonth.AddDate(4, 0, 0)
+			s.EndMonth = &end
+		}
+		if _, err := st.Create(ctx, s); err != nil {
+			b.Fatalf("create: %v", err)
+		}
+	}
+}
+
+func BenchmarkSummary(b *testing.B) {
+	f := SummaryFilter{
+		UserID: &summaryUser, From: month("01-2021"), To: month("12-2025"),
+		GroupBy:  []domain.SummaryGroup{domain.GroupByMonth, domain.GroupByService},
+		Currency: "RUB", BaseCurrency: "RUB", Basis: domain.BasisCharges,
+	}
+	run := func(b *testing.B, st Store) {
+		seedBench(b, st)
+		ctx := context.Background()
+		for b.Loop() {
+			if _, err := st.Summary(ctx, f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("memory", func(b *testing.B) { run(b, NewMemory()) })
+	b.Run("postgres", func(b *testing.B) {
+		if os.Getenv("TEST_DB_DSN") == "" {
+			b.Skip("TEST_DB_DSN is not set")
+		}
+		run(b, newTestRepo(b))
+	})
+}
+
+// newTestRepo migrates a new schema in the TEST_DB_DSN database and returns
+// a Repo using it.
+func newTestRepo(tb testing.TB) *Repo {
+	tb.Helper()
+	tb.Setenv("MIGRATIONS_DIR", "../../migrations")
+	ctx := context.Background()
+	cfg, err := pgxpool.ParseConfig(os.Getenv("TEST_DB_DSN"))
+	if err != nil {
+		tb.Fatalf("parse TEST_DB_DSN: %v", err)
+	}
+	admin, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		tb.Fatalf("connect: %v", err)
+	}
+	tb.Cleanup(admin.Close)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		tb.Fatalf("create schema: %v", err)
+	}
+	tb.Cleanup(func() {
+		if _, err := admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			tb.Errorf("drop schema: %v", err)
+		}
+	})
+
+	cfg = cfg.Copy()
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		tb.Fatalf("connect: %v", err)
+	}
+	r := &Repo{db: pool}
+	tb.Cleanup(r.Close)
+	if err := r.applyMigrations(ctx); err != nil {
+		tb.Fatalf("migrate: %v", err)
+	}
+	return r
+}
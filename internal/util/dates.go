@@ -12,10 +12,33 @@ func ParseMonth(s string) (time.Time, error) {
 	}
 	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
 }
+
+// ParseDate accepts either MM-YYYY or YYYY-MM-DD. withDay reports whether
+// the value had day precision; month-only values resolve to the 1st.
+func ParseDate(s string) (t time.Time, withDay bool, err error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	t, err = ParseMonth(s)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date format (expected MM-YYYY or YYYY-MM-DD): %w", err)
+	}
+	return t, false, nil
+}
+
 func MonthStr(t time.Time) string {
 	return t.Format("01-2006")
 }
 
+func DateStr(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// MonthStart truncates t to the first day of its month.
+func MonthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
 func MonthsOverlap(aStart time.Time, aEnd *time.Time, bStart, bEnd time.Time) int {
 	aTo := aEnd
 	if aTo == nil {
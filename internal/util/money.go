@@ -0,0 +1,10 @@
+package util
+
+import "math"
+
+// ConvertMinor converts amount minor units of a currency with fromExp minor
+// digits and rate fromRate to a currency with toExp minor digits and rate
+// toRate. Rates are expressed against a common base currency.
+func ConvertMinor(amount int, fromExp, toExp int, fromRate, toRate float64) float64 {
+	return float64(amount) / math.Pow10(fromExp) * fromRate / toRate * math.Pow10(toExp)
+}
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS trg_set_updated_at ON subscriptions;
+DROP FUNCTION IF EXISTS set_updated_at();
+DROP TABLE IF EXISTS subscriptions;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS exchange_rates;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS currency;
+UPDATE subscriptions SET price = price / 100;
+ALTER TABLE subscriptions ALTER COLUMN price TYPE INTEGER;
@@ -0,0 +1,13 @@
+-- Prices are now stored in minor units (kopecks, cents) of their currency.
+ALTER TABLE subscriptions ALTER COLUMN price TYPE BIGINT;
+UPDATE subscriptions SET price = price * 100;
+ALTER TABLE subscriptions ADD COLUMN currency TEXT NOT NULL DEFAULT 'RUB';
+
+-- rate is the price of one major unit of currency in the base currency,
+-- effective from month until the next entry for the same currency.
+CREATE TABLE exchange_rates (
+    currency TEXT           NOT NULL,
+    month    DATE           NOT NULL,
+    rate     NUMERIC(20,10) NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (currency, month)
+);
@@ -0,0 +1,9 @@
+-- Scheduled and past price changes. subscriptions.price is the price in
+-- effect from start_month until the first change.
+CREATE TABLE subscription_prices (
+    subscription_id UUID        NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    effective_from  DATE        NOT NULL,
+    price           BIGINT      NOT NULL CHECK (price >= 0),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (subscription_id, effective_from)
+);
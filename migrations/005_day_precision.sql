@@ -0,0 +1,7 @@
+-- Exact start and end days for subscriptions created with YYYY-MM-DD dates.
+-- start_month/end_month keep holding the months for month-level queries.
+ALTER TABLE subscriptions
+    ADD COLUMN start_date DATE,
+    ADD COLUMN end_date   DATE,
+    ADD CONSTRAINT chk_start_date_month CHECK (start_date IS NULL OR date_trunc('month', start_date)::date = start_month),
+    ADD CONSTRAINT chk_end_date_month   CHECK (end_date IS NULL OR date_trunc('month', end_date)::date = end_month);
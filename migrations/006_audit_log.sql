@@ -0,0 +1,13 @@
+-- Audit trail of subscription mutations. There is deliberately no foreign
+-- key so the history outlives deleted subscriptions.
+CREATE TABLE subscription_events (
+    id              BIGSERIAL   PRIMARY KEY,
+    subscription_id UUID        NOT NULL,
+    actor           TEXT        NOT NULL,
+    action          TEXT        NOT NULL,
+    before          JSONB,
+    after           JSONB,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_subscription_events_sub ON subscription_events(subscription_id, id);
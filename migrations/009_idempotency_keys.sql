@@ -0,0 +1,11 @@
+-- Idempotency-Key of POST /subscriptions. Expired rows are reused by the
+-- next request with the same key and removed by the admin purge.
+CREATE TABLE idempotency_keys (
+    key             TEXT        PRIMARY KEY,
+    request_hash    TEXT        NOT NULL,
+    subscription_id UUID,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_subs_service_name_trgm;
@@ -0,0 +1,6 @@
+-- Case-insensitive prefix and substring search on service_name
+-- (GET /subscriptions?service_match=prefix|contains) uses ILIKE, which a
+-- trigram index can serve.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_subs_service_name_trgm ON subscriptions USING gin (service_name gin_trgm_ops);
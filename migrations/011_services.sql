@@ -0,0 +1,25 @@
+-- Service catalog. Every name and alias is registered in service_keys
+-- normalized by domain.ServiceKey, which keeps them unique across services
+-- and is what service_name is resolved against.
+CREATE TABLE services (
+    id            UUID        PRIMARY KEY,
+    name          TEXT        NOT NULL,
+    aliases       TEXT[]      NOT NULL DEFAULT '{}',
+    category      TEXT,
+    default_price BIGINT      CHECK (default_price >= 0),
+    currency      TEXT        NOT NULL,
+    vendor_url    TEXT,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE service_keys (
+    key        TEXT PRIMARY KEY,
+    service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_service_keys_service ON service_keys(service_id);
+
+ALTER TABLE subscriptions ADD COLUMN service_id UUID REFERENCES services(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_subs_service_id ON subscriptions(service_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_subs_tags;
+DROP INDEX IF EXISTS idx_subs_category;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS tags, DROP COLUMN IF EXISTS category;
@@ -0,0 +1,8 @@
+-- Budget category and free-form tags. Both are stored normalized by
+-- domain.NormalizeLabel; tags are kept sorted and distinct.
+ALTER TABLE subscriptions
+    ADD COLUMN category TEXT,
+    ADD COLUMN tags     TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_subs_category ON subscriptions(category);
+CREATE INDEX idx_subs_tags ON subscriptions USING gin (tags);
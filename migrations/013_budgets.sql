@@ -0,0 +1,16 @@
+-- Monthly spending limits. A budget applies to all subscriptions of the
+-- user, or only to those of a category and/or service; there is at most
+-- one budget per scope.
+CREATE TABLE budgets (
+    id            UUID        PRIMARY KEY,
+    user_id       UUID        NOT NULL,
+    category      TEXT,
+    service_name  TEXT,
+    monthly_limit BIGINT      NOT NULL CHECK (monthly_limit >= 0),
+    currency      TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX idx_budgets_scope
+    ON budgets(user_id, COALESCE(category, ''), COALESCE(service_name, ''));
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_subs_trial_until;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS trial_until;
@@ -0,0 +1,5 @@
+-- Last day of a free trial (inclusive). Charges falling on or before it are
+-- not counted by summaries and forecasts.
+ALTER TABLE subscriptions ADD COLUMN trial_until DATE;
+
+CREATE INDEX idx_subs_trial_until ON subscriptions(trial_until) WHERE trial_until IS NOT NULL;
@@ -0,0 +1,11 @@
+-- Months in which a subscription is paused and not charged. paused_until is
+-- the last paused month, NULL until the subscription is resumed. Pauses of
+-- a subscription never overlap; the application checks this under the
+-- subscription's row lock.
+CREATE TABLE subscription_pauses (
+    subscription_id UUID        NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    paused_from     DATE        NOT NULL,
+    paused_until    DATE        CHECK (paused_until >= paused_from),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (subscription_id, paused_from)
+);
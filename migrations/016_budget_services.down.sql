@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_budgets_service_id;
+ALTER TABLE budgets DROP COLUMN IF EXISTS service_id;
@@ -0,0 +1,6 @@
+-- Budgets scoped to a service are linked to its catalog entry and carry
+-- its canonical name, like subscriptions, so they follow renames. Existing
+-- budgets are linked when they or the matching service next change.
+ALTER TABLE budgets ADD COLUMN service_id UUID REFERENCES services(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_budgets_service_id ON budgets(service_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_subs_service_key_missing;
+DROP INDEX IF EXISTS idx_budgets_service_key;
+DROP INDEX IF EXISTS idx_subs_service_key;
+ALTER TABLE budgets DROP COLUMN IF EXISTS service_key;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS service_key;
@@ -0,0 +1,10 @@
+-- service_key holds domain.ServiceKey(service_name), written by the
+-- application so that names are normalized by one rule only. Subscriptions
+-- and budgets not linked to a service are relinked by it when the catalog
+-- changes. Rows written before this migration are filled in at startup.
+ALTER TABLE subscriptions ADD COLUMN service_key TEXT;
+ALTER TABLE budgets ADD COLUMN service_key TEXT;
+
+CREATE INDEX idx_subs_service_key ON subscriptions(service_key) WHERE service_id IS NULL;
+CREATE INDEX idx_budgets_service_key ON budgets(service_key) WHERE service_id IS NULL;
+CREATE INDEX idx_subs_service_key_missing ON subscriptions(id) WHERE service_key IS NULL;